@@ -70,18 +70,27 @@ type DNSZoneRecordsResponse struct {
 }
 
 func GetDnsZones(c echo.Context) error {
-	body, err := authenticatedDNSRequest("zones/list", [][]string{})
+	zones, err := listDNSZones()
 	if err != nil {
 		log.Println("Error fetching dns zones: ", err)
 		return c.JSON(500, "could not fetch dns zones")
 	}
 
+	return c.JSON(200, zones)
+}
+
+// listDNSZones returns the zones in Technitium without the internal ones
+func listDNSZones() ([]string, error) {
+	body, err := authenticatedDNSRequest("zones/list", [][]string{})
+	if err != nil {
+		return nil, err
+	}
+
 	var dnsResponse DNSZonesResponse
 
 	err = json.Unmarshal(body, &dnsResponse)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 
 	// remove internal zones
@@ -92,7 +101,7 @@ func GetDnsZones(c echo.Context) error {
 		}
 	}
 
-	return c.JSON(200, zones)
+	return zones, nil
 }
 func GetDnsRecordsForServer(c echo.Context) error {
 	serverId := c.Param("serverId")
@@ -163,6 +172,31 @@ func CreateDnsRecord(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "could not connect to database")
 	}
 
+	userId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+	if !isAdmin {
+		quotaTx, err := lockQuota(db, userId)
+		if err != nil {
+			log.Println("Error locking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "could not check quota")
+		}
+		defer quotaTx.Rollback()
+
+		// an AAAA record next to an A record of the same subdomain is not counted again
+		extra := Quota{DNSRecords: 1}
+		if subDomainInUse(request.Parent, subdomainWithPrefix, db) {
+			extra.DNSRecords = 0
+		}
+
+		quotaMessage, err := checkQuota(quotaTx, userId, extra)
+		if err != nil {
+			log.Println("Error checking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "could not check quota")
+		}
+		if quotaMessage != "" {
+			return c.JSON(http.StatusBadRequest, quotaMessage)
+		}
+	}
+
 	err = createDNSRecord(db, subdomainWithPrefix, request.Parent, request.RecordValue, request.Ttl, request.Type, serverId)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
@@ -367,7 +401,7 @@ func createRecordInDNS(zone, domain, ttl, recordType, recordValue string) error
 func deleteRecordInDNS(zone, domain, recordType, recordValue string) (string, error) {
 	queries := [][]string{
 		{"zone", zone},
-		{"domain", domain + zone},
+		{"domain", strings.TrimSuffix(domain, ".") + "." + zone},
 		{"type", recordType},
 	}
 
@@ -462,7 +496,12 @@ func appendRecordValueWithCorrectTypeToQueries(queries [][]string, recordType, r
 			queries = append(queries, []string{"flags", split[0]}, []string{"tag", split[1]}, []string{"value", split[2]})
 		}
 	case "AAAA":
-		queries = append(queries, []string{"ipAddress", recordValue})
+		{
+			if !isIPv6(recordValue) {
+				return queries, fmt.Errorf("only ipv6 addresses are allowed for AAAA records")
+			}
+			queries = append(queries, []string{"ipAddress", recordValue})
+		}
 	case "PTR":
 		queries = append(queries, []string{"ptrName", recordValue})
 	case "CNAME":
@@ -508,6 +547,70 @@ func getRecordsInTechnitium(zone, subDomain string, listZone bool) ([]string, er
 	return records, nil
 }
 
+// technitiumRecord is a record in Technitium with its value in the format of record_value in sub_domains
+type technitiumRecord struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// getZoneRecordsInTechnitium returns every record of the zone, records of types the platform doesn't make have no value
+func getZoneRecordsInTechnitium(zone string) ([]technitiumRecord, error) {
+	body, err := authenticatedDNSRequest("zones/records/get", [][]string{{"zone", zone}, {"domain", zone}, {"listZone", "true"}})
+	if err != nil {
+		return nil, err
+	}
+
+	var dnsResponse struct {
+		Response struct {
+			Records []struct {
+				Name  string                 `json:"name"`
+				Type  string                 `json:"type"`
+				RData map[string]interface{} `json:"rData"`
+			} `json:"records"`
+		} `json:"response"`
+	}
+
+	err = json.Unmarshal(body, &dnsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []technitiumRecord
+	for _, record := range dnsResponse.Response.Records {
+		records = append(records, technitiumRecord{
+			Name:  record.Name,
+			Type:  record.Type,
+			Value: technitiumRecordValue(record.Type, record.RData),
+		})
+	}
+
+	return records, nil
+}
+
+// technitiumRecordValue joins the rData of a record the same way appendRecordValueWithCorrectTypeToQueries splits it
+func technitiumRecordValue(recordType string, rData map[string]interface{}) string {
+	fields := map[string][]string{
+		"A":     {"ipAddress"},
+		"AAAA":  {"ipAddress"},
+		"MX":    {"preference", "exchange"},
+		"SRV":   {"priority", "weight", "port", "target"},
+		"CAA":   {"flags", "tag", "value"},
+		"PTR":   {"ptrName"},
+		"CNAME": {"cname"},
+		"TXT":   {"text"},
+		"DNAME": {"dname"},
+		"ANAME": {"aname"},
+	}[recordType]
+
+	var values []string
+	for _, field := range fields {
+		values = append(values, fmt.Sprint(rData[field]))
+	}
+
+	return strings.Join(values, " ")
+}
+
 func checkIfUserAlreadyHasRecordInDB(db *sql.DB, parent, subdomain, recordType, recordValue string) bool {
 	rows, err := db.Query("SELECT * FROM sub_domains WHERE parent_domain = ? AND subDomain = ? AND record_type = ? AND record_value = ?", parent, subdomain, recordType, recordValue)
 	if err != nil {
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type serverCloneJsonBody struct {
+	// SIDs, usernames or student IDs of the users that get a clone
+	Students []string `json:"students"`
+	// the name of the clones, when empty the name of the source server is used
+	Name    *string `json:"name"`
+	EndDate string  `json:"end_date"`
+	// every clone gets the student ID after the subdomain, so sub_domain "web" becomes web-123456
+	SubDomain  *string `json:"sub_domain"`
+	DomainZone *string `json:"domain_zone"`
+}
+
+type bulkServerJsonBody struct {
+	// the DN of the LDAP group whose members get a server, or a CSV with a student ID in the first column
+	GroupDN         string  `json:"group_dn" form:"group_dn"`
+	Csv             string  `json:"csv" form:"csv"`
+	Name            string  `json:"name" form:"name"`
+	Description     string  `json:"description" form:"description"`
+	OperatingSystem string  `json:"operating_system" form:"operating_system"`
+	Profile         string  `json:"profile" form:"profile"`
+	EndDate         string  `json:"end_date" form:"end_date"`
+	Network         *string `json:"network" form:"network"`
+	// every server gets the student ID after the subdomain, so sub_domain "web" becomes web-123456
+	SubDomain  *string `json:"sub_domain" form:"sub_domain"`
+	DomainZone *string `json:"domain_zone" form:"domain_zone"`
+}
+
+// adminServerResult is the result for one user when an admin makes servers for several users at once
+type adminServerResult struct {
+	Student string `json:"student"`
+	JobID   int64  `json:"job_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func CloneServer(c echo.Context) error {
+	var jsonBody serverCloneJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	if len(jsonBody.Students) == 0 {
+		return c.JSON(http.StatusBadRequest, "No students given to clone the server to")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+	if vCenterID == "" {
+		return c.JSON(http.StatusConflict, "The server is still being made, try again later")
+	}
+
+	serverID := stringToInt64(c.Param("id"))
+
+	// a clone copies every disk, data disks would end up on the clones without being counted against the quota
+	var disks int
+	err = db.QueryRow("SELECT COUNT(*) FROM server_disks WHERE virtual_machines_id = ?", serverID).Scan(&disks)
+	if err != nil {
+		log.Println("Error counting data disks: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	if disks > 0 {
+		return c.JSON(http.StatusConflict, "Servers with data disks can't be cloned, remove the data disks first")
+	}
+
+	request := serverCreationJsonBody{EndDate: jsonBody.EndDate, DomainZone: jsonBody.DomainZone}
+	err = db.QueryRow("SELECT name, description, operating_system, cpu, storage, memory FROM virtual_machines WHERE id = ?", serverID).
+		Scan(&request.Name, &request.Description, &request.OperatingSystem, &request.Cpu, &request.Storage, &request.Memory)
+	if err != nil {
+		log.Println("Error fetching server: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	if jsonBody.Name != nil {
+		request.Name = *jsonBody.Name
+	}
+
+	// the clones are on the same network as the source, because they keep its network adapter
+	if network := getServerNetwork(db, serverID); network != "" {
+		request.Network = &network
+	}
+
+	valid, errMessage, endDate := validateServerCreation(c.Request().Context(), &request)
+	if !valid {
+		return c.JSON(http.StatusBadRequest, errMessage)
+	}
+
+	results := []adminServerResult{}
+	for _, student := range jsonBody.Students {
+		result := adminServerResult{Student: student}
+
+		owner, err := fetchUserWithIdentifier(student)
+		if err != nil {
+			log.Println("Error fetching user: ", err)
+			result.Error = "Can't find user"
+			results = append(results, result)
+			continue
+		}
+
+		clone := request
+		if jsonBody.SubDomain != nil && jsonBody.DomainZone != nil {
+			subDomain := *jsonBody.SubDomain + "-" + strings.ToLower(owner.StudentID)
+			clone.SubDomain = &subDomain
+		}
+
+		result.JobID, err = queueServerForOwner(db, owner, "clone", clone, endDate, vCenterID)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// CreateServersInBulk makes the same server for every member of an LDAP group or every student in a CSV,
+// the CSV can be sent as the csv field or uploaded as a file named csv
+func CreateServersInBulk(c echo.Context) error {
+	var jsonBody bulkServerJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	if file, err := c.FormFile("csv"); err == nil {
+		src, err := file.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid CSV file")
+		}
+		defer src.Close()
+
+		content, err := io.ReadAll(src)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid CSV file")
+		}
+		jsonBody.Csv = string(content)
+	}
+
+	if (jsonBody.GroupDN == "") == (jsonBody.Csv == "") {
+		return c.JSON(http.StatusBadRequest, "Give either an LDAP group or a CSV of student IDs")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	profile, err := getHardwareProfileByName(db, jsonBody.Profile)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid hardware profile")
+	}
+
+	request := serverCreationJsonBody{
+		Name:            jsonBody.Name,
+		Description:     jsonBody.Description,
+		OperatingSystem: jsonBody.OperatingSystem,
+		EndDate:         jsonBody.EndDate,
+		Cpu:             profile.Cpu,
+		Memory:          profile.Memory,
+		Storage:         profile.Storage,
+		Network:         jsonBody.Network,
+		DomainZone:      jsonBody.DomainZone,
+	}
+
+	valid, errMessage, endDate := validateServerCreation(c.Request().Context(), &request)
+	if !valid {
+		return c.JSON(http.StatusBadRequest, errMessage)
+	}
+
+	results := []adminServerResult{}
+	var owners []ldapUser
+	if jsonBody.GroupDN != "" {
+		owners, err = fetchUsersInGroup(jsonBody.GroupDN)
+		if err != nil {
+			log.Println("Error fetching group members: ", err)
+			return c.JSON(http.StatusBadRequest, "Can't find members of that group")
+		}
+	} else {
+		studentIDs, err := parseStudentIDsCsv(jsonBody.Csv)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid CSV")
+		}
+
+		for _, studentID := range studentIDs {
+			owner, err := fetchUserWithIdentifier(studentID)
+			if err != nil {
+				log.Println("Error fetching user: ", err)
+				results = append(results, adminServerResult{Student: studentID, Error: "Can't find user"})
+				continue
+			}
+			owners = append(owners, owner)
+		}
+	}
+
+	for _, owner := range owners {
+		result := adminServerResult{Student: owner.StudentID}
+
+		server := request
+		if jsonBody.SubDomain != nil && jsonBody.DomainZone != nil {
+			subDomain := *jsonBody.SubDomain + "-" + strings.ToLower(owner.StudentID)
+			server.SubDomain = &subDomain
+		}
+
+		result.JobID, err = queueServerForOwner(db, owner, "create", server, endDate, "")
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// parseStudentIDsCsv returns the first column of every row, empty rows, a header and duplicates are skipped
+func parseStudentIDsCsv(content string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	// rows don't need the same amount of columns
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var studentIDs []string
+	for i, record := range records {
+		studentID := strings.TrimSpace(record[0])
+		if studentID == "" || seen[studentID] {
+			continue
+		}
+		if i == 0 && strings.EqualFold(strings.ReplaceAll(studentID, " ", "_"), "student_id") {
+			continue
+		}
+
+		seen[studentID] = true
+		studentIDs = append(studentIDs, studentID)
+	}
+
+	return studentIDs, nil
+}
+
+// queueServerForOwner reserves the IPs and the row of a server that an admin makes for someone else and starts its
+// provisioning job, the error can be shown to the admin
+func queueServerForOwner(db *sql.DB, owner ldapUser, kind string, request serverCreationJsonBody, endDate time.Time, sourceVcenterId string) (int64, error) {
+	if checkIfUserAlreadyHasServerWithName(request.Name, owner.SID, db) {
+		return 0, fmt.Errorf("User already has a server named %s", request.Name)
+	}
+
+	if request.SubDomain != nil && request.DomainZone != nil {
+		// strip any dots from the subdomain to make sure it's a parent
+		subdomain := strings.TrimSuffix(*request.SubDomain+"."+getEnvVar("SUBDOMAIN_PREFIX"), ".")
+		if subDomainInUse(*request.DomainZone, subdomain, db) {
+			return 0, fmt.Errorf("The subdomain %s is already in use", *request.SubDomain)
+		}
+	}
+
+	network := getNetworkForServerCreation(&request)
+	_, err := getNetworkByName(db, network)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid network")
+	}
+	request.Network = &network
+
+	ip, err := allocateIp(network, 4)
+	if err != nil {
+		log.Println("Error allocating IP: ", err)
+		return 0, fmt.Errorf("No IP addresses available")
+	}
+
+	// IPv6 is still being rolled out, so servers on a network without free IPv6 addresses only get IPv4
+	ipv6, err := allocateIp(network, 6)
+	if err != nil {
+		log.Println("No IPv6 address allocated: ", err)
+		ipv6 = ""
+	}
+
+	serverID, err := createServerInDB(owner.SID, owner.StudentID, &request, endDate, db)
+	if err != nil {
+		log.Println("Error creating server: ", err)
+		unclaimIp(ip)
+		unclaimIp(ipv6)
+		return 0, fmt.Errorf("Error creating server")
+	}
+
+	jobID, err := createProvisioningJob(db, &provisioningJob{
+		Kind:            kind,
+		UsersId:         owner.SID,
+		StudentID:       owner.StudentID,
+		FullName:        owner.FullName,
+		Request:         request,
+		IP:              ip,
+		IPv6:            ipv6,
+		ServerId:        serverID,
+		SourceVcenterId: sourceVcenterId,
+	})
+	if err != nil {
+		log.Println("Error creating provisioning job: ", err)
+		deleteServerFromDB(request.Name, owner.SID, db)
+		unclaimIp(ip)
+		unclaimIp(ipv6)
+		return 0, fmt.Errorf("Error creating server")
+	}
+
+	go runProvisioningJob(jobID)
+
+	return jobID, nil
+}
+
+type serverTransferJsonBody struct {
+	// SID, username or student ID of the new owner
+	Owner string `json:"owner"`
+}
+
+// TransferServer gives the server to another user, the Sophos objects and the VM are renamed because they contain the student ID
+func TransferServer(c echo.Context) error {
+	var jsonBody serverTransferJsonBody
+	if err := c.Bind(&jsonBody); err != nil || jsonBody.Owner == "" {
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, oldOwnerId, err := getServerForUser(c, db)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	serverID := stringToInt64(c.Param("id"))
+
+	busy, err := serverHasActiveJob(db, serverID)
+	if err != nil {
+		log.Println("Error checking provisioning jobs: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	if busy || vCenterID == "" {
+		return c.JSON(http.StatusConflict, "The server is still being made or rebuilt, try again later")
+	}
+
+	newOwner, err := fetchUserWithIdentifier(jsonBody.Owner)
+	if err != nil {
+		log.Println("Error fetching new owner: ", err)
+		return c.JSON(http.StatusBadRequest, "Can't find the new owner")
+	}
+	if newOwner.SID == oldOwnerId {
+		return c.JSON(http.StatusBadRequest, "The server is already owned by this user")
+	}
+
+	oldStudentID, err := getServerStudentID(db, int(serverID), oldOwnerId)
+	if err != nil {
+		log.Println("Error fetching old owner: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	var name, ip, ipv6 string
+	err = db.QueryRow("SELECT name, ip, ipv6 FROM virtual_machines WHERE id = ?", serverID).Scan(&name, &ip, &ipv6)
+	if err != nil {
+		log.Println("Error fetching server: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	if checkIfUserAlreadyHasServerWithName(name, newOwner.SID, db) {
+		return c.JSON(http.StatusConflict, "The new owner already has a server with this name")
+	}
+
+	network, err := getNetworkByName(db, getServerNetwork(db, serverID))
+	if err != nil {
+		log.Println("Error fetching network: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	// the new objects are made before the old ones are removed, so the server stays reachable when something fails
+	err = createFirewallRuleForServerCreation(ip, ipv6, newOwner.StudentID, name, network.SophosZone)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Error creating the firewall rules for the new owner")
+	}
+
+	_, err = db.Exec("UPDATE virtual_machines SET users_id = ?, student_id = ?, updated_at = NOW() WHERE id = ?", newOwner.SID, newOwner.StudentID, serverID)
+	if err != nil {
+		log.Println("Error updating owner: ", err)
+		removeFirewallFromServerInSophos(newOwner.StudentID, name)
+		if ipv6 != "" {
+			removeIPv6HostInSophos(newOwner.StudentID, name)
+		}
+		return c.JSON(http.StatusInternalServerError, "Error transferring server")
+	}
+
+	// the server already works for the new owner, what is left behind is cleaned up by the reconciler
+	err = removeFirewallFromServerInSophos(oldStudentID, name)
+	if err == nil && ipv6 != "" {
+		err = removeIPv6HostInSophos(oldStudentID, name)
+	}
+	if err != nil {
+		log.Println("Error removing firewall of old owner: ", err)
+		logErrorInDB(err)
+	}
+
+	err = renamevCenterVM(c.Request().Context(), vCenterID, "OICT-AUTO-"+newOwner.StudentID+"-"+name)
+	if err != nil {
+		log.Println("Error renaming VM: ", err)
+		logErrorInDB(err)
+	}
+
+	// the password and SSH keys of the old owner are still in the guest, only a rebuild removes them
+	notifyServerOwner(oldOwnerId, "Server overgedragen", "Je server("+name+") is overgedragen aan "+newOwner.FullName+", je kan hem niet meer beheren. Log niet meer in op de server, je gebruiker en SSH keys blijven werken tot de nieuwe eigenaar hem opnieuw installeert.", db)
+	notifyServerOwner(newOwner.SID, "Server overgedragen", "De server("+name+") met het ip: "+ip+" is aan jou overgedragen, je kan hem nu beheren. Let op: het wachtwoord en de SSH keys van de vorige eigenaar werken nog, installeer de server opnieuw of verwijder die gebruiker en keys.", db)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Server transferred!",
+		"warning": "The password and SSH keys of the previous owner still work on the server until it is rebuilt or they are removed",
+	})
+}
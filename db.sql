@@ -53,14 +53,17 @@ CREATE TABLE `virtual_machines`
 (
     `id`               bigint       NOT NULL AUTO_INCREMENT,
     `users_id`         text         NOT NULL,
+    `student_id`       varchar(255) NOT NULL DEFAULT '',
     `vcenter_id`       varchar(100) NOT NULL,
     `name`             varchar(100) NOT NULL,
     `description`      text         NOT NULL,
     `end_date`         date         NOT NULL,
     `operating_system` varchar(100) NOT NULL,
+    `cpu`              int          NOT NULL DEFAULT 0,
     `storage`          int          NOT NULL,
     `memory`           mediumint    NOT NULL,
     `ip`               varchar(15)  NOT NULL,
+    `ipv6`             varchar(45)  NOT NULL DEFAULT '',
     `deleted_at`       timestamp    NULL DEFAULT NULL,
     `created_at`       text,
     `updated_at`       timestamp    NULL DEFAULT NULL,
@@ -71,10 +74,78 @@ CREATE TABLE `virtual_machines`
 
 -- --------------------------------------------------------
 
+CREATE TABLE `server_disks`
+(
+    `id`                  bigint      NOT NULL AUTO_INCREMENT,
+    `virtual_machines_id` bigint      NOT NULL,
+    -- the ID of the disk in vCenter, the root disk of the template is 2000
+    `vcenter_disk_id`     varchar(20) NOT NULL,
+    `capacity`            int         NOT NULL,
+    `created_at`          timestamp   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `virtual_machines_id` (`virtual_machines_id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
+CREATE TABLE `networks`
+(
+    `id`              bigint       NOT NULL AUTO_INCREMENT,
+    `name`            varchar(100) NOT NULL,
+    `vcenter_network` varchar(100) NOT NULL,
+    `sophos_zone`     varchar(100) NOT NULL,
+    `gateway`         varchar(49)  NOT NULL DEFAULT '',
+    `gateway_ipv6`    varchar(49)  NOT NULL DEFAULT '',
+    `dns_servers`     varchar(255) NOT NULL DEFAULT '',
+    `created_at`      timestamp    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `name` (`name`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `hardware_profiles`
+(
+    `id`         bigint       NOT NULL AUTO_INCREMENT,
+    `name`       varchar(100) NOT NULL,
+    `cpu`        int          NOT NULL,
+    `memory`     int          NOT NULL,
+    `storage`    int          NOT NULL,
+    `created_at` timestamp    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `name` (`name`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+INSERT INTO `hardware_profiles` (`name`, `cpu`, `memory`, `storage`)
+VALUES ('small', 1, 1, 20),
+       ('medium', 2, 2, 20),
+       ('large', 4, 4, 40);
+
+CREATE TABLE `ip_pools`
+(
+    `id`         bigint       NOT NULL AUTO_INCREMENT,
+    `name`       varchar(100) NOT NULL,
+    `cidr`       varchar(49)  NOT NULL DEFAULT '',
+    `min`        varchar(45)  NOT NULL,
+    `max`        varchar(45)  NOT NULL,
+    `network_id` bigint       NULL,
+    `created_at` timestamp    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `name` (`name`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
 CREATE TABLE `ip_adresses`
 (
-    `ip`                 varchar(15) NOT NULL,
+    `ip`                 varchar(45) NOT NULL,
     `virtual_machine_id` varchar(10) NULL,
+    `claimed_at`         timestamp   NULL DEFAULT NULL,
+    `pool_id`            bigint      NULL,
     `created_at`         timestamp   NOT NULL DEFAULT CURRENT_TIMESTAMP,
     `updated_at`         timestamp   NOT NULL DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY (`ip`)
@@ -82,6 +153,90 @@ CREATE TABLE `ip_adresses`
   DEFAULT CHARSET = utf8mb4
   COLLATE = utf8mb4_0900_ai_ci;
 
+CREATE TABLE `provisioning_jobs`
+(
+    `id`          bigint                                         NOT NULL AUTO_INCREMENT,
+    `kind`        enum ('create', 'rebuild', 'clone')            NOT NULL DEFAULT 'create',
+    `users_id`    varchar(255)                                   NOT NULL,
+    `student_id`  varchar(255)                                   NOT NULL,
+    `full_name`   varchar(255)                                   NOT NULL,
+    `server_name` varchar(100)                                   NOT NULL,
+    `request`     text                                           NOT NULL,
+    `ip`          varchar(15)                                    NOT NULL,
+    `ipv6`        varchar(45)                                    NOT NULL DEFAULT '',
+    `vcenter_id`  varchar(100)                                   NOT NULL DEFAULT '',
+    `server_id`   bigint                                         NULL,
+    `source_vcenter_id` varchar(100)                             NOT NULL DEFAULT '',
+    `status`      enum ('pending', 'running', 'done', 'failed') NOT NULL DEFAULT 'pending',
+    `created_at`  timestamp                                      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at`  timestamp                                      NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `provisioning_job_steps`
+(
+    `id`          bigint                                         NOT NULL AUTO_INCREMENT,
+    `job_id`      bigint                                         NOT NULL,
+    `step`        varchar(50)                                    NOT NULL,
+    `position`    int                                            NOT NULL,
+    `status`      enum ('pending', 'running', 'done', 'failed') NOT NULL DEFAULT 'pending',
+    `error`       text                                           NULL,
+    `started_at`  timestamp                                      NULL DEFAULT NULL,
+    `finished_at` timestamp                                      NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `job_step` (`job_id`, `step`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
+CREATE TABLE `expiry_notices`
+(
+    `id`                  bigint      NOT NULL AUTO_INCREMENT,
+    `virtual_machines_id` bigint      NOT NULL,
+    `end_date`            date        NOT NULL,
+    `notice`              varchar(20) NOT NULL,
+    `created_at`          timestamp   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `server_notice` (`virtual_machines_id`, `end_date`, `notice`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
+CREATE TABLE `quotas`
+(
+    `id`              bigint                 NOT NULL AUTO_INCREMENT,
+    `subject_type`    enum ('user', 'group') NOT NULL,
+    -- the SID of the user or the CN of the LDAP group
+    `subject`         varchar(255)           NOT NULL,
+    `max_servers`     int                    NOT NULL,
+    `max_memory`      int                    NOT NULL,
+    `max_storage`     int                    NOT NULL,
+    `max_dns_records` int                    NOT NULL,
+    `created_at`      timestamp              NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at`      timestamp              NULL     DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `subject` (`subject_type`, `subject`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+-- one row per user that is locked while a request checks and uses the quota of the user
+CREATE TABLE `quota_locks`
+(
+    `users_id` varchar(255) NOT NULL,
+    PRIMARY KEY (`users_id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
+-- --------------------------------------------------------
+
 CREATE TABLE `sub_domains`
 (
     `id`                  INT          NOT NULL AUTO_INCREMENT,
@@ -111,6 +266,22 @@ CREATE TABLE `tickets`
   DEFAULT CHARSET = utf8mb4
   COLLATE = utf8mb4_0900_ai_ci;
 
+-- --------------------------------------------------------
+CREATE TABLE `ssh_keys`
+(
+    `id`          bigint       NOT NULL AUTO_INCREMENT,
+    `users_id`    varchar(255) NOT NULL,
+    `name`        varchar(100) NOT NULL,
+    `public_key`  text         NOT NULL,
+    -- SHA256 fingerprint like ssh-keygen -l shows it
+    `fingerprint` varchar(100) NOT NULL,
+    `created_at`  timestamp    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_fingerprint` (`users_id`, `fingerprint`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4
+  COLLATE = utf8mb4_0900_ai_ci;
+
 -- --------------------------------------------------------
 CREATE TABLE `notifications`
 (
@@ -4,6 +4,7 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
 )
 
 func getEnvVar(varName string) string {
@@ -29,3 +30,13 @@ func getBoolEnvVar(varname string) bool {
 		return false
 	}
 }
+
+// getIntEnvVar returns the env var as an int, or the fallback if it isn't set or isn't a number
+func getIntEnvVar(varName string, fallback int) int {
+	value, err := strconv.Atoi(getEnvVar(varName))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -56,7 +57,47 @@ func createIPHostInSopohos(ip, studentID, name string) error {
 	return nil
 }
 
-func createSophosFirewallRules(studentID, name string) error {
+// createIPv6HostInSophos makes the IPv6 host of a dual-stack server, it is added to the same rules as the IPv4 host
+func createIPv6HostInSophos(ip, studentID, name string) error {
+	requestXML := fmt.Sprintf(`
+                    <Set operation="add">
+                		<IPHost>
+                			<Name>OICT-AUTO-HOST6-%s-%s</Name>
+                			<IPFamily>IPv6</IPFamily>
+                			<HostType>IP</HostType>
+                			<IPAddress>%s</IPAddress>
+                		</IPHost>
+                	</Set>`, studentID, name, ip)
+
+	resp := doAuthenticatedSophosRequest(requestXML)
+
+	// parse response
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println(err)
+	}
+
+	// check if the response is an error
+	if !strings.Contains(string(body), `<Status code="200">`) {
+		return fmt.Errorf("error creating IPv6 host in Sophos: %s", string(body))
+	}
+
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// sophosServerHostNetworks returns the IP hosts of the server as networks for a firewall rule
+func sophosServerHostNetworks(studentID, name string, hasIPv6 bool) string {
+	networks := fmt.Sprintf("<Network>OICT-AUTO-HOST-%s-%s</Network>", studentID, name)
+	if hasIPv6 {
+		networks += fmt.Sprintf("<Network>OICT-AUTO-HOST6-%s-%s</Network>", studentID, name)
+	}
+
+	return networks
+}
+
+func createSophosFirewallRules(studentID, name, zone string, hasIPv6 bool) error {
 	var wg sync.WaitGroup
 	var inboundErr, outboundErr error
 
@@ -65,7 +106,7 @@ func createSophosFirewallRules(studentID, name string) error {
 	// create inbound and outbound rules concurrently to save a bit of time
 	go func() {
 		defer wg.Done()
-		inboundErr = createInBoundRuleInSophos(studentID, name)
+		inboundErr = createInBoundRuleInSophos(studentID, name, zone, hasIPv6)
 		if inboundErr != nil {
 			log.Println("Error creating inbound rule: ", inboundErr)
 		}
@@ -73,7 +114,7 @@ func createSophosFirewallRules(studentID, name string) error {
 
 	go func() {
 		defer wg.Done()
-		outboundErr = createOutBoundRuleInSophos(studentID, name)
+		outboundErr = createOutBoundRuleInSophos(studentID, name, zone, hasIPv6)
 		if outboundErr != nil {
 			log.Println("Error creating outbound rule: ", outboundErr)
 		}
@@ -89,7 +130,7 @@ func createSophosFirewallRules(studentID, name string) error {
 	return outboundErr
 }
 
-func createInBoundRuleInSophos(studentId, name string) error {
+func createInBoundRuleInSophos(studentId, name, zone string, hasIPv6 bool) error {
 	xml := fmt.Sprintf(`
                         <Set operation="add">
                             <FirewallRule>
@@ -109,14 +150,14 @@ func createInBoundRuleInSophos(studentId, name string) error {
                                         %s
                                     </Services>
                                     <DestinationZones>
-                                        <Zone>DMZ</Zone>
+                                        <Zone>%s</Zone>
                                     </DestinationZones>
                                     <DestinationNetworks>
-                                        <Network>OICT-AUTO-HOST-%s-%s</Network>
+                                        %s
                                     </DestinationNetworks>
                                 </NetworkPolicy>
                             </FirewallRule>
-                        </Set>`, studentId, name, sourceNetworks, inboundServices, studentId, name)
+                        </Set>`, studentId, name, sourceNetworks, inboundServices, zone, sophosServerHostNetworks(studentId, name, hasIPv6))
 
 	resp := doAuthenticatedSophosRequest(xml)
 
@@ -134,7 +175,7 @@ func createInBoundRuleInSophos(studentId, name string) error {
 	return nil
 }
 
-func createOutBoundRuleInSophos(studentId, name string) error {
+func createOutBoundRuleInSophos(studentId, name, zone string, hasIPv6 bool) error {
 	xml := fmt.Sprintf(`
                         <Set operation="add">
                             <FirewallRule>
@@ -144,11 +185,11 @@ func createOutBoundRuleInSophos(studentId, name string) error {
                                 <NetworkPolicy>
                                     <Action>Accept</Action>
                                     <SourceZones>
-                                        <Zone>DMZ</Zone>
+                                        <Zone>%s</Zone>
                                         <Zone>LAN</Zone>
                                     </SourceZones>
                                     <SourceNetworks>
-                                        <Network>OICT-AUTO-HOST-%s-%s</Network>
+                                        %s
                                     </SourceNetworks>
                                     <Services>
                                         %s
@@ -160,7 +201,7 @@ func createOutBoundRuleInSophos(studentId, name string) error {
                                     </DestinationNetworks>
                                 </NetworkPolicy>
                             </FirewallRule>
-                        </Set>`, studentId, name, studentId, name, outboundServices)
+                        </Set>`, studentId, name, zone, sophosServerHostNetworks(studentId, name, hasIPv6), outboundServices)
 
 	resp := doAuthenticatedSophosRequest(xml)
 
@@ -279,6 +320,10 @@ func getSophosIpHost() (string, error) {
                     </Get>`)
 
 	resp := doAuthenticatedSophosRequest(requestXML)
+	if resp == nil {
+		return "", fmt.Errorf("could not reach Sophos")
+	}
+	defer resp.Body.Close()
 
 	// parse response
 	body, err := ioutil.ReadAll(resp.Body)
@@ -289,6 +334,31 @@ func getSophosIpHost() (string, error) {
 	return string(body), nil
 }
 
+// getSophosIpHostNames returns the names of all the IP hosts in Sophos
+func getSophosIpHostNames() ([]string, error) {
+	ipHost, err := getSophosIpHost()
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		IPHosts []struct {
+			Name string `xml:"Name"`
+		} `xml:"IPHost"`
+	}
+	err = xml.Unmarshal([]byte(ipHost), &response)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing IP hosts from Sophos: %v", err)
+	}
+
+	var names []string
+	for _, host := range response.IPHosts {
+		names = append(names, host.Name)
+	}
+
+	return names, nil
+}
+
 func addIpToSophos(studentID, ip string, count int) error {
 	reqXML := fmt.Sprintf(`
                     <Set operation="add">
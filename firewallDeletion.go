@@ -65,6 +65,30 @@ func removeIPHostInSophos(studentID, name string) error {
 
 	return nil
 }
+func removeIPv6HostInSophos(studentID, name string) error {
+	xml := fmt.Sprintf(`
+                    <Remove>
+                        <IPHost>
+                            <Name>OICT-AUTO-HOST6-%s-%s</Name>
+                        </IPHost>
+                    </Remove>`, studentID, name)
+
+	resp := doAuthenticatedSophosRequest(xml)
+
+	// parse response
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println(err)
+	}
+
+	// check if the response is an error
+	if !strings.Contains(string(body), `<Status code="200">`) {
+		return fmt.Errorf("error removing IPv6 host in Sophos: %s", string(body))
+	}
+
+	return nil
+}
+
 func removeInBoundRuleInSophos(studentId, name string) error {
 	xml := fmt.Sprintf(`
                         <Remove>
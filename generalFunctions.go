@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"log"
+	"net/netip"
 	"strconv"
 	"strings"
 )
@@ -28,6 +30,11 @@ func ip2long(ip string) uint32 {
 	return uint32(ipLong)
 }
 
+func isIPv6(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && addr.Is6() && !addr.Is4In6()
+}
+
 func isIPv4(ip string) bool {
 	parts := strings.Split(ip, ".")
 	if len(parts) != 4 {
@@ -43,3 +50,10 @@ func isIPv4(ip string) bool {
 
 	return true
 }
+
+func nullStringToPointer(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
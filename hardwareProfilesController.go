@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+// HardwareProfile is a size users can pick when making a server, memory and storage are in GB
+type HardwareProfile struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Cpu     int    `json:"cpu"`
+	Memory  int    `json:"memory"`
+	Storage int    `json:"storage"`
+}
+
+func GetHardwareProfiles(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, name, cpu, memory, storage FROM hardware_profiles ORDER BY cpu, memory, storage")
+	if err != nil {
+		log.Println("Error fetching hardware profiles: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to fetch hardware profiles")
+	}
+	defer rows.Close()
+
+	profiles := []HardwareProfile{}
+	for rows.Next() {
+		var profile HardwareProfile
+		err = rows.Scan(&profile.ID, &profile.Name, &profile.Cpu, &profile.Memory, &profile.Storage)
+		if err != nil {
+			log.Println("Error scanning hardware profile: ", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to fetch hardware profiles")
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return c.JSON(http.StatusOK, profiles)
+}
+
+func CreateHardwareProfile(c echo.Context) error {
+	var profile HardwareProfile
+	if err := c.Bind(&profile); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid request")
+	}
+
+	if profile.Name == "" || profile.Cpu < 1 || profile.Memory < 1 || profile.Storage < 1 {
+		return c.JSON(http.StatusBadRequest, "A hardware profile needs a name and at least 1 cpu, 1 GB of memory and 1 GB of storage")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	result, err := db.Exec("INSERT INTO hardware_profiles (name, cpu, memory, storage) VALUES (?, ?, ?, ?)", profile.Name, profile.Cpu, profile.Memory, profile.Storage)
+	if err != nil {
+		log.Println("Error creating hardware profile: ", err)
+		return c.JSON(http.StatusBadRequest, "Failed to create hardware profile, the name might already be in use")
+	}
+
+	profile.ID, _ = result.LastInsertId()
+
+	return c.JSON(http.StatusCreated, profile)
+}
+
+// DeleteHardwareProfile only removes the profile, servers that were made with it keep their hardware
+func DeleteHardwareProfile(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM hardware_profiles WHERE id = ?", c.Param("id"))
+	if err != nil {
+		log.Println("Error deleting hardware profile: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to delete hardware profile")
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return c.JSON(http.StatusNotFound, "Hardware profile not found")
+	}
+
+	return c.JSON(http.StatusOK, "Hardware profile deleted")
+}
+
+func getHardwareProfileByName(db *sql.DB, name string) (HardwareProfile, error) {
+	var profile HardwareProfile
+	err := db.QueryRow("SELECT id, name, cpu, memory, storage FROM hardware_profiles WHERE name = ?", name).
+		Scan(&profile.ID, &profile.Name, &profile.Cpu, &profile.Memory, &profile.Storage)
+
+	return profile, err
+}
@@ -35,3 +35,124 @@ func CreateIpAdress(c echo.Context) error {
 
 	return c.JSON(http.StatusCreated, map[string]string{"message": "Ip addresses created"})
 }
+
+func CreateIpPool(c echo.Context) error {
+	var pool ipPoolJsonBody
+	if err := c.Bind(&pool); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if pool.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A pool needs a name"})
+	}
+
+	if pool.Cidr == "" && (pool.Min == "" || pool.Max == "") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A pool needs a cidr or a min and max address"})
+	}
+
+	addresses, err := expandIpPool(pool)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if len(addresses) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "The pool has no addresses left after the exclusions"})
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to connect to database"})
+	}
+	defer db.Close()
+
+	poolID, failedIpAddresses, err := createIpPool(db, pool, addresses)
+	if err != nil {
+		log.Println("Error creating ip pool: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create ip pool"})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{"message": "Ip pool created", "id": poolID, "failedIpAddresses": failedIpAddresses})
+}
+
+func GetIpPools(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to connect to database"})
+	}
+	defer db.Close()
+
+	pools, err := getIpPoolUsage(db)
+	if err != nil {
+		log.Println("Error fetching ip pools: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ip pools"})
+	}
+
+	return c.JSON(http.StatusOK, pools)
+}
+
+func GetIpPoolAddresses(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to connect to database"})
+	}
+	defer db.Close()
+
+	addresses, err := getIpPoolAddresses(db, c.Param("id"))
+	if err != nil {
+		log.Println("Error fetching ip addresses: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ip addresses"})
+	}
+
+	return c.JSON(http.StatusOK, addresses)
+}
+
+// ReleaseIpAdress gives a claimed or quarantined IP back to the pool, IPs of existing servers and of servers that
+// are still being made or rebuilt can't be released
+func ReleaseIpAdress(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to connect to database"})
+	}
+	defer db.Close()
+
+	result, err := db.Exec(`UPDATE ip_adresses SET virtual_machine_id = NULL, claimed_at = NULL
+		WHERE ip = ? AND virtual_machine_id NOT IN (SELECT vcenter_id FROM virtual_machines)
+		AND ip NOT IN (SELECT ip FROM provisioning_jobs WHERE status IN ('pending', 'running'))
+		AND ip NOT IN (SELECT ipv6 FROM provisioning_jobs WHERE status IN ('pending', 'running'))`, c.Param("ip"))
+	if err != nil {
+		log.Println("Error releasing ip address: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to release ip address"})
+	}
+
+	if released, _ := result.RowsAffected(); released == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Ip address is free, unknown or still used by a server"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Ip address released"})
+}
+
+// QuarantineIpAdress takes a free IP out of the pool so it won't be given to new servers
+func QuarantineIpAdress(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to connect to database"})
+	}
+	defer db.Close()
+
+	result, err := db.Exec("UPDATE ip_adresses SET virtual_machine_id = ? WHERE ip = ? AND virtual_machine_id IS NULL", quarantinedIp, c.Param("ip"))
+	if err != nil {
+		log.Println("Error quarantining ip address: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to quarantine ip address"})
+	}
+
+	if quarantined, _ := result.RowsAffected(); quarantined == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Ip address is unknown or not free"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Ip address quarantined"})
+}
@@ -1,48 +1,100 @@
 package main
 
-import "log"
-
-func findEmptyIp() string {
+import (
+	"fmt"
+	"log"
+)
+
+// allocateIp finds a free IP of the family (4 or 6) on the network and claims it in one transaction, the row is locked
+// so two servers that are made at the same time can't get the same IP. An empty network can use any free IP that is
+// not in a pool of a network, those are only for servers that are put on that network
+func allocateIp(network string, family int) (string, error) {
 	db, err := connectToDB()
 	if err != nil {
 		log.Println("Error connecting to database: ", err)
-		return ""
+		return "", err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// IPv6 addresses are the only ones with a colon in them
+	familyFilter := "ip.ip NOT LIKE '%:%'"
+	if family == 6 {
+		familyFilter = "ip.ip LIKE '%:%'"
 	}
 
 	var ip string
-	err = db.QueryRow("SELECT ip FROM ip_adresses WHERE virtual_machine_id IS NULL LIMIT 1").Scan(&ip)
+	if network == "" {
+		err = tx.QueryRow(`SELECT ip.ip FROM ip_adresses ip
+			LEFT JOIN ip_pools p ON ip.pool_id = p.id
+			WHERE ip.virtual_machine_id IS NULL AND (ip.pool_id IS NULL OR p.network_id IS NULL) AND ` + familyFilter + ` LIMIT 1 FOR UPDATE SKIP LOCKED`).Scan(&ip)
+	} else {
+		err = tx.QueryRow(`SELECT ip.ip FROM ip_adresses ip
+			JOIN ip_pools p ON ip.pool_id = p.id
+			JOIN networks n ON p.network_id = n.id
+			WHERE ip.virtual_machine_id IS NULL AND n.name = ? AND `+familyFilter+` LIMIT 1 FOR UPDATE SKIP LOCKED`, network).Scan(&ip)
+	}
 	if err != nil {
 		log.Println("Error executing query: ", err)
-		return ""
+		return "", err
 	}
 
-	return ip
+	result, err := tx.Exec("UPDATE ip_adresses SET virtual_machine_id = 'claimed', claimed_at = NOW() WHERE ip = ? AND virtual_machine_id IS NULL", ip)
+	if err != nil {
+		log.Println("Error executing query: ", err)
+		return "", err
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if claimed != 1 {
+		return "", fmt.Errorf("IP %s was claimed by another server", ip)
+	}
+
+	return ip, tx.Commit()
 }
 
-func assignIPToVM(ip string, vmID string) error {
+// expireStaleIpClaims is run by the scheduler and frees the IPs that were claimed for a server that never got made,
+// IPs of provisioning jobs that are still running are left alone
+func expireStaleIpClaims() {
 	db, err := connectToDB()
 	if err != nil {
 		log.Println("Error connecting to database: ", err)
-		return err
+		return
 	}
+	defer db.Close()
 
-	_, err = db.Exec("UPDATE ip_adresses SET virtual_machine_id = ? WHERE ip = ?", vmID, ip)
+	result, err := db.Exec(`UPDATE ip_adresses SET virtual_machine_id = NULL, claimed_at = NULL
+		WHERE virtual_machine_id = 'claimed'
+		AND (claimed_at IS NULL OR claimed_at < NOW() - INTERVAL ? MINUTE)
+		AND ip NOT IN (SELECT ip FROM provisioning_jobs WHERE status IN ('pending', 'running'))
+		AND ip NOT IN (SELECT ipv6 FROM provisioning_jobs WHERE status IN ('pending', 'running'))`, getIntEnvVar("IP_LEASE_MINUTES", 60))
 	if err != nil {
-		log.Println("Error executing query: ", err)
-		return err
+		log.Println("Error expiring claimed IPs: ", err)
+		return
 	}
 
-	return nil
+	expired, _ := result.RowsAffected()
+	if expired > 0 {
+		log.Println("Expired stale IP claims: ", expired)
+	}
 }
 
-func claimIp(ip string) error {
+func assignIPToVM(ip string, vmID string) error {
 	db, err := connectToDB()
 	if err != nil {
 		log.Println("Error connecting to database: ", err)
 		return err
 	}
 
-	_, err = db.Exec("UPDATE ip_adresses SET virtual_machine_id = 'claimed' WHERE ip = ?", ip)
+	_, err = db.Exec("UPDATE ip_adresses SET virtual_machine_id = ?, claimed_at = NULL WHERE ip = ?", vmID, ip)
 	if err != nil {
 		log.Println("Error executing query: ", err)
 		return err
@@ -74,7 +126,24 @@ func unassignIPfromVM(vmID string) error {
 		log.Println("Error connecting to database", err)
 	}
 
-	_, err = db.Exec("UPDATE ip_adresses SET virtual_machine_id = NULL WHERE virtual_machine_id = ?", vmID)
+	_, err = db.Exec("UPDATE ip_adresses SET virtual_machine_id = NULL, claimed_at = NULL WHERE virtual_machine_id = ?", vmID)
+	if err != nil {
+		log.Println("Error executing query: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// unclaimIp frees an IP that was claimed for a VM that never got made
+func unclaimIp(ip string) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return err
+	}
+
+	_, err = db.Exec("UPDATE ip_adresses SET virtual_machine_id = NULL, claimed_at = NULL WHERE ip = ? AND virtual_machine_id = 'claimed'", ip)
 	if err != nil {
 		log.Println("Error executing query: ", err)
 		return err
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/netip"
+)
+
+// the value of virtual_machine_id for an IP that an admin has taken out of the pool
+const quarantinedIp = "quarantine"
+
+// the most addresses a single pool can have, so a typo in a CIDR doesn't fill the database
+const maxIpPoolSize = 65536
+
+type ipPoolJsonBody struct {
+	Name     string   `json:"name"`
+	Cidr     string   `json:"cidr"`
+	Min      string   `json:"min"`
+	Max      string   `json:"max"`
+	Excluded []string `json:"excluded"`
+	Network  string   `json:"network"`
+}
+
+type IpPoolUsage struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Cidr        string `json:"cidr"`
+	Min         string `json:"min"`
+	Max         string `json:"max"`
+	Network     string `json:"network"`
+	Total       int    `json:"total"`
+	Free        int    `json:"free"`
+	Used        int    `json:"used"`
+	Claimed     int    `json:"claimed"`
+	Quarantined int    `json:"quarantined"`
+}
+
+type IpPoolAddress struct {
+	IP         string  `json:"ip"`
+	Status     string  `json:"status"`
+	VcenterId  *string `json:"vcenter_id"`
+	ServerID   *int64  `json:"server_id"`
+	ServerName *string `json:"server_name"`
+	UsersId    *string `json:"users_id"`
+}
+
+// expandIpPool returns every address of the pool without the excluded ones, both IPv4 and IPv6 pools are supported.
+// For a CIDR the network and broadcast address are left out as well
+func expandIpPool(pool ipPoolJsonBody) ([]string, error) {
+	var first, last netip.Addr
+
+	if pool.Cidr != "" {
+		prefix, err := netip.ParsePrefix(pool.Cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s", pool.Cidr)
+		}
+		prefix = prefix.Masked()
+
+		// checked before the loop, an IPv6 /64 would otherwise be walked until the limit is hit
+		if prefix.Addr().BitLen()-prefix.Bits() > 16 {
+			return nil, fmt.Errorf("CIDR %s is bigger than %d addresses, use a min and max address for IPv6 pools", pool.Cidr, maxIpPoolSize)
+		}
+
+		first = prefix.Addr()
+		last = lastAddrOfPrefix(prefix)
+
+		// IPv6 has no broadcast address, only the subnet-router anycast address at the start
+		if prefix.Addr().Is6() {
+			first = first.Next()
+		} else if prefix.Bits() < 31 {
+			first = first.Next()
+			last = last.Prev()
+		}
+	} else {
+		var err error
+		first, err = netip.ParseAddr(pool.Min)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min address %s", pool.Min)
+		}
+		last, err = netip.ParseAddr(pool.Max)
+		if err != nil || last.Is4() != first.Is4() {
+			return nil, fmt.Errorf("invalid max address %s", pool.Max)
+		}
+	}
+
+	if last.Less(first) {
+		return nil, fmt.Errorf("the last address of the pool is before the first one")
+	}
+
+	size := new(big.Int).Sub(new(big.Int).SetBytes(last.AsSlice()), new(big.Int).SetBytes(first.AsSlice()))
+	if size.Cmp(big.NewInt(maxIpPoolSize)) >= 0 {
+		return nil, fmt.Errorf("pool is bigger than %d addresses", maxIpPoolSize)
+	}
+
+	excluded := make(map[string]bool)
+	for _, ip := range pool.Excluded {
+		excluded[ip] = true
+	}
+
+	var addresses []string
+	for addr := first; addr.IsValid() && !last.Less(addr); addr = addr.Next() {
+		if len(addresses) >= maxIpPoolSize {
+			return nil, fmt.Errorf("pool is bigger than %d addresses", maxIpPoolSize)
+		}
+		if excluded[addr.String()] {
+			continue
+		}
+		addresses = append(addresses, addr.String())
+	}
+
+	return addresses, nil
+}
+
+func lastAddrOfPrefix(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	hostBits := len(bytes)*8 - prefix.Bits()
+
+	// set every host bit to 1, starting at the last byte
+	for i := len(bytes) - 1; i >= 0 && hostBits > 0; i-- {
+		if hostBits >= 8 {
+			bytes[i] = 0xff
+			hostBits -= 8
+		} else {
+			bytes[i] |= byte(1<<hostBits) - 1
+			hostBits = 0
+		}
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// createIpPool stores the pool and all of its addresses, addresses that are already in another pool are returned as failed
+func createIpPool(db *sql.DB, pool ipPoolJsonBody, addresses []string) (int64, []string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var networkID sql.NullInt64
+	if pool.Network != "" {
+		network, err := getNetworkByName(db, pool.Network)
+		if err != nil {
+			return 0, nil, fmt.Errorf("network %s does not exist", pool.Network)
+		}
+		networkID = sql.NullInt64{Int64: network.ID, Valid: true}
+	}
+
+	result, err := tx.Exec("INSERT INTO ip_pools (name, cidr, min, max, network_id) VALUES (?, ?, ?, ?, ?)", pool.Name, pool.Cidr, addresses[0], addresses[len(addresses)-1], networkID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	poolID, err := result.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var failedIpAddresses []string
+	for _, ip := range addresses {
+		_, err = tx.Exec("INSERT INTO ip_adresses (ip, pool_id) VALUES (?, ?)", ip, poolID)
+		if err != nil {
+			failedIpAddresses = append(failedIpAddresses, ip)
+		}
+	}
+
+	return poolID, failedIpAddresses, tx.Commit()
+}
+
+func getIpPoolUsage(db *sql.DB) ([]IpPoolUsage, error) {
+	// addresses that were added without a pool are shown as pool 0
+	rows, err := db.Query(`SELECT COALESCE(p.id, 0), COALESCE(p.name, 'unassigned'), COALESCE(p.cidr, ''), COALESCE(p.min, ''), COALESCE(p.max, ''), COALESCE(n.name, ''),
+		COUNT(ip.ip),
+		COALESCE(SUM(ip.virtual_machine_id IS NULL), 0),
+		COALESCE(SUM(ip.virtual_machine_id IS NOT NULL AND ip.virtual_machine_id NOT IN ('claimed', ?)), 0),
+		COALESCE(SUM(ip.virtual_machine_id = 'claimed'), 0),
+		COALESCE(SUM(ip.virtual_machine_id = ?), 0)
+		FROM ip_adresses ip LEFT JOIN ip_pools p ON ip.pool_id = p.id LEFT JOIN networks n ON p.network_id = n.id
+		GROUP BY p.id, p.name, p.cidr, p.min, p.max, n.name
+		ORDER BY p.id`, quarantinedIp, quarantinedIp)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pools := []IpPoolUsage{}
+	for rows.Next() {
+		var pool IpPoolUsage
+		err = rows.Scan(&pool.ID, &pool.Name, &pool.Cidr, &pool.Min, &pool.Max, &pool.Network, &pool.Total, &pool.Free, &pool.Used, &pool.Claimed, &pool.Quarantined)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+func getIpPoolAddresses(db *sql.DB, poolID string) ([]IpPoolAddress, error) {
+	rows, err := db.Query(`SELECT ip.ip, ip.virtual_machine_id, vm.id, vm.name, vm.users_id
+		FROM ip_adresses ip LEFT JOIN virtual_machines vm ON vm.vcenter_id = ip.virtual_machine_id AND vm.vcenter_id != ''
+		WHERE COALESCE(ip.pool_id, 0) = ?
+		ORDER BY INET6_ATON(ip.ip)`, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []IpPoolAddress{}
+	for rows.Next() {
+		var (
+			address             IpPoolAddress
+			vcenterId           sql.NullString
+			serverID            sql.NullInt64
+			serverName, usersId sql.NullString
+		)
+		err = rows.Scan(&address.IP, &vcenterId, &serverID, &serverName, &usersId)
+		if err != nil {
+			return nil, err
+		}
+
+		address.Status = ipStatus(vcenterId)
+		address.VcenterId = nullStringToPointer(vcenterId)
+		address.ServerName = nullStringToPointer(serverName)
+		address.UsersId = nullStringToPointer(usersId)
+		if serverID.Valid {
+			address.ServerID = &serverID.Int64
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses, nil
+}
+
+func ipStatus(virtualMachineID sql.NullString) string {
+	switch {
+	case !virtualMachineID.Valid:
+		return "free"
+	case virtualMachineID.String == "claimed":
+		return "claimed"
+	case virtualMachineID.String == quarantinedIp:
+		return "quarantined"
+	default:
+		return "used"
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExpandIpPool(t *testing.T) {
+	tests := []struct {
+		name      string
+		pool      ipPoolJsonBody
+		wantErr   bool
+		wantCount int
+		wantFirst string
+		wantLast  string
+	}{
+		{
+			name:      "IPv4 CIDR without network and broadcast address",
+			pool:      ipPoolJsonBody{Cidr: "10.0.0.0/29"},
+			wantCount: 6,
+			wantFirst: "10.0.0.1",
+			wantLast:  "10.0.0.6",
+		},
+		{
+			name:      "CIDR that isn't the start of the network",
+			pool:      ipPoolJsonBody{Cidr: "10.0.0.5/30"},
+			wantCount: 2,
+			wantFirst: "10.0.0.5",
+			wantLast:  "10.0.0.6",
+		},
+		{
+			name:      "IPv4 /31 keeps both addresses",
+			pool:      ipPoolJsonBody{Cidr: "10.0.0.0/31"},
+			wantCount: 2,
+			wantFirst: "10.0.0.0",
+			wantLast:  "10.0.0.1",
+		},
+		{
+			name:      "IPv6 CIDR only skips the subnet-router anycast address",
+			pool:      ipPoolJsonBody{Cidr: "2001:db8::/126"},
+			wantCount: 3,
+			wantFirst: "2001:db8::1",
+			wantLast:  "2001:db8::3",
+		},
+		{
+			name:      "min and max with excluded addresses",
+			pool:      ipPoolJsonBody{Min: "10.0.0.10", Max: "10.0.0.14", Excluded: []string{"10.0.0.10", "10.0.0.12"}},
+			wantCount: 3,
+			wantFirst: "10.0.0.11",
+			wantLast:  "10.0.0.14",
+		},
+		{
+			name:      "IPv6 min and max",
+			pool:      ipPoolJsonBody{Min: "2001:db8::100", Max: "2001:db8::1ff"},
+			wantCount: 256,
+			wantFirst: "2001:db8::100",
+			wantLast:  "2001:db8::1ff",
+		},
+		{
+			name:      "largest pool",
+			pool:      ipPoolJsonBody{Min: "2001:db8::", Max: "2001:db8::ffff"},
+			wantCount: maxIpPoolSize,
+			wantFirst: "2001:db8::",
+			wantLast:  "2001:db8::ffff",
+		},
+		{name: "IPv6 /64 is too big", pool: ipPoolJsonBody{Cidr: "2001:db8::/64"}, wantErr: true},
+		{name: "IPv4 /15 is too big", pool: ipPoolJsonBody{Cidr: "10.0.0.0/15"}, wantErr: true},
+		{name: "min and max range that is too big", pool: ipPoolJsonBody{Min: "2001:db8::", Max: "2001:db8::1:0"}, wantErr: true},
+		{name: "invalid CIDR", pool: ipPoolJsonBody{Cidr: "10.0.0.0/33"}, wantErr: true},
+		{name: "invalid min", pool: ipPoolJsonBody{Min: "10.0.0", Max: "10.0.0.5"}, wantErr: true},
+		{name: "min and max of different families", pool: ipPoolJsonBody{Min: "10.0.0.1", Max: "2001:db8::1"}, wantErr: true},
+		{name: "max before min", pool: ipPoolJsonBody{Min: "10.0.0.5", Max: "10.0.0.1"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses, err := expandIpPool(test.pool)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %d addresses, want an error", len(addresses))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if len(addresses) != test.wantCount {
+				t.Fatalf("got %d addresses, want %d", len(addresses), test.wantCount)
+			}
+			if addresses[0] != test.wantFirst || addresses[len(addresses)-1] != test.wantLast {
+				t.Errorf("got %s - %s, want %s - %s", addresses[0], addresses[len(addresses)-1], test.wantFirst, test.wantLast)
+			}
+		})
+	}
+}
@@ -71,6 +71,137 @@ func fetchUserInfoWithSID(sid string) (string, string, string, string, error) {
 	return fullName, description, sid, email, nil
 }
 
+// getStudentIDWithSID returns the student ID of the user, which is stored in the description in LDAP
+func getStudentIDWithSID(sid string) (string, error) {
+	_, description, _, _, err := fetchUserInfoWithSID(sid)
+	if err != nil {
+		return "", err
+	}
+
+	return description, nil
+}
+
+// fetchGroupsWithSID returns the CNs of the groups the user is a member of
+func fetchGroupsWithSID(sid string) ([]string, error) {
+	ldapConn, err := connectAndBind(getEnvVar("LDAP_READ_USER"), getEnvVar("LDAP_READ_PASS"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+	defer ldapConn.Close()
+
+	searchRequest := ldap.NewSearchRequest(
+		getEnvVar("LDAP_BASE_DN"),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=user)(objectSid=%s))", sid),
+		[]string{"memberOf"},
+		nil,
+	)
+
+	sr, err := ldapConn.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search LDAP server: %v", err)
+	}
+
+	if len(sr.Entries) == 0 {
+		return nil, fmt.Errorf("no entries found for user with SID %s", sid)
+	}
+
+	return getCNs(sr.Entries[0].GetAttributeValues("memberOf")), nil
+}
+
+// ldapUser is a user that servers are made for by an admin
+type ldapUser struct {
+	SID       string
+	FullName  string
+	StudentID string
+	Email     string
+}
+
+// fetchUserWithIdentifier finds a user by SID, sAMAccountName or student ID, so admins can use whatever they have at hand
+func fetchUserWithIdentifier(identifier string) (ldapUser, error) {
+	if strings.HasPrefix(identifier, "S-1-") {
+		fullName, studentID, sid, email, err := fetchUserInfoWithSID(ldap.EscapeFilter(identifier))
+		if err != nil {
+			return ldapUser{}, err
+		}
+		return ldapUser{SID: sid, FullName: fullName, StudentID: studentID, Email: email}, nil
+	}
+
+	ldapConn, err := connectAndBind(getEnvVar("LDAP_READ_USER"), getEnvVar("LDAP_READ_PASS"))
+	if err != nil {
+		return ldapUser{}, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+	defer ldapConn.Close()
+
+	// the student ID is stored in the description
+	searchRequest := ldap.NewSearchRequest(
+		getEnvVar("LDAP_BASE_DN"),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=user)(|(sAMAccountName=%s)(description=%s)))", ldap.EscapeFilter(identifier), ldap.EscapeFilter(identifier)),
+		[]string{"givenName", "sn", "description", "mail", "objectSid"},
+		nil,
+	)
+
+	sr, err := ldapConn.Search(searchRequest)
+	if err != nil {
+		return ldapUser{}, fmt.Errorf("failed to search LDAP server: %v", err)
+	}
+
+	if len(sr.Entries) == 0 {
+		return ldapUser{}, fmt.Errorf("no entries found for user %s", identifier)
+	}
+	if len(sr.Entries) > 1 {
+		return ldapUser{}, fmt.Errorf("more than one user found for %s", identifier)
+	}
+
+	return ldapUserFromEntry(sr.Entries[0]), nil
+}
+
+// fetchUsersInGroup returns the users that are a direct member of the group with the given DN
+func fetchUsersInGroup(groupDN string) ([]ldapUser, error) {
+	ldapConn, err := connectAndBind(getEnvVar("LDAP_READ_USER"), getEnvVar("LDAP_READ_PASS"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+	defer ldapConn.Close()
+
+	searchRequest := ldap.NewSearchRequest(
+		getEnvVar("LDAP_BASE_DN"),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=user)(memberOf=%s))", ldap.EscapeFilter(groupDN)),
+		[]string{"givenName", "sn", "description", "mail", "objectSid"},
+		nil,
+	)
+
+	// a whole year of students is more than the size limit of AD allows in one page
+	sr, err := ldapConn.SearchWithPaging(searchRequest, 500)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search LDAP server: %v", err)
+	}
+
+	var users []ldapUser
+	for _, entry := range sr.Entries {
+		users = append(users, ldapUserFromEntry(entry))
+	}
+
+	return users, nil
+}
+
+func ldapUserFromEntry(entry *ldap.Entry) ldapUser {
+	// last name is an array for some reason so we have to check if it exists
+	var lastName string
+	if len(entry.GetAttributeValues("sn")) >= 1 {
+		lastName = entry.GetAttributeValues("sn")[0]
+	}
+
+	return ldapUser{
+		SID:       sidToString(entry.GetRawAttributeValue("objectSid")),
+		FullName:  entry.GetAttributeValue("givenName") + " " + lastName,
+		StudentID: entry.GetAttributeValue("description"),
+		Email:     entry.GetAttributeValue("mail"),
+	}
+}
+
 func fetchUserInfoWithEmail(email string) (string, string, string, string, string, error) {
 	// Connect to LDAP
 	ldapConn, err := connectAndBind(getEnvVar("LDAP_READ_USER"), getEnvVar("LDAP_READ_PASS"))
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"net/netip"
+)
+
+// the Sophos zone servers are put in when they are not on a network from the networks table
+const defaultSophosZone = "DMZ"
+
+type Network struct {
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	VcenterNetwork string `json:"vcenter_network"`
+	SophosZone     string `json:"sophos_zone"`
+	// the gateways in CIDR notation (10.0.0.1/24) and the comma separated DNS servers, these are only needed
+	// for templates that configure the network with a guest customization
+	Gateway     string `json:"gateway"`
+	GatewayIPv6 string `json:"gateway_ipv6"`
+	DnsServers  string `json:"dns_servers"`
+}
+
+func GetNetworks(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, name, vcenter_network, sophos_zone, gateway, gateway_ipv6, dns_servers FROM networks ORDER BY name")
+	if err != nil {
+		log.Println("Error fetching networks: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to fetch networks")
+	}
+	defer rows.Close()
+
+	networks := []Network{}
+	for rows.Next() {
+		var network Network
+		err = rows.Scan(&network.ID, &network.Name, &network.VcenterNetwork, &network.SophosZone, &network.Gateway, &network.GatewayIPv6, &network.DnsServers)
+		if err != nil {
+			log.Println("Error scanning network: ", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to fetch networks")
+		}
+		networks = append(networks, network)
+	}
+
+	return c.JSON(http.StatusOK, networks)
+}
+
+func CreateNetwork(c echo.Context) error {
+	var network Network
+	if err := c.Bind(&network); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid request")
+	}
+
+	if network.Name == "" || network.VcenterNetwork == "" || network.SophosZone == "" {
+		return c.JSON(http.StatusBadRequest, "A network needs a name, vcenter_network and sophos_zone")
+	}
+
+	for _, gateway := range []string{network.Gateway, network.GatewayIPv6} {
+		if _, err := netip.ParsePrefix(gateway); gateway != "" && err != nil {
+			return c.JSON(http.StatusBadRequest, "A gateway has to be in CIDR notation, like 10.0.0.1/24")
+		}
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	result, err := db.Exec("INSERT INTO networks (name, vcenter_network, sophos_zone, gateway, gateway_ipv6, dns_servers) VALUES (?, ?, ?, ?, ?, ?)",
+		network.Name, network.VcenterNetwork, network.SophosZone, network.Gateway, network.GatewayIPv6, network.DnsServers)
+	if err != nil {
+		log.Println("Error creating network: ", err)
+		return c.JSON(http.StatusBadRequest, "Failed to create network, the name might already be in use")
+	}
+
+	network.ID, _ = result.LastInsertId()
+
+	return c.JSON(http.StatusCreated, network)
+}
+
+// getNetworkByName returns the network, an empty name returns the default network that doesn't change anything in vCenter
+func getNetworkByName(db *sql.DB, name string) (Network, error) {
+	if name == "" {
+		return Network{SophosZone: defaultSophosZone}, nil
+	}
+
+	var network Network
+	err := db.QueryRow("SELECT id, name, vcenter_network, sophos_zone, gateway, gateway_ipv6, dns_servers FROM networks WHERE name = ?", name).
+		Scan(&network.ID, &network.Name, &network.VcenterNetwork, &network.SophosZone, &network.Gateway, &network.GatewayIPv6, &network.DnsServers)
+
+	return network, err
+}
+
+// getNetworkForServerCreation picks the network the user asked for, otherwise the one of the template, otherwise the default one
+func getNetworkForServerCreation(json *serverCreationJsonBody) string {
+	if json.Network != nil {
+		return *json.Network
+	}
+
+	script, err := readStartScript(json.OperatingSystem)
+	if err == nil && script.Network != "" {
+		return script.Network
+	}
+
+	return getEnvVar("DEFAULT_NETWORK")
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type provisioningJob struct {
+	ID int64
+	// create, rebuild or clone
+	Kind       string
+	UsersId    string
+	StudentID  string
+	FullName   string
+	ServerName string
+	Request    serverCreationJsonBody
+	IP         string
+	IPv6       string
+	VcenterId  string
+	ServerId   int64
+	Status     string
+	// the VM a clone is made from
+	SourceVcenterId string
+}
+
+// provisioningStep is one step of the server creation, every step gets its own row in provisioning_job_steps
+// Retryable steps can safely be run again when the API was restarted while the step was running,
+// for the other steps we can't know how far they got, so the job gets cleaned up instead
+type provisioningStep struct {
+	Name      string
+	Retryable bool
+	// the serverCreationStep that handleFailedCreation needs to clean up after this step is done
+	CreationStep string
+	Run          func(job *provisioningJob, db *sql.DB) error
+}
+
+var provisioningSteps = []provisioningStep{
+	{Name: "db", Retryable: true, CreationStep: "made in db", Run: func(job *provisioningJob, db *sql.DB) error { return nil }},
+	{Name: "vcenter", Retryable: true, CreationStep: "made in vCenter", Run: provisionVCenterStep},
+	{Name: "sophos", Retryable: false, CreationStep: "made in sophos", Run: provisionSophosStep},
+	{Name: "ip", Retryable: true, CreationStep: "made in ip", Run: provisionIpStep},
+	{Name: "power_on", Retryable: true, CreationStep: "made in ip", Run: provisionPowerOnStep},
+	{Name: "start_script", Retryable: false, CreationStep: "made in ip", Run: provisionStartScriptStep},
+	{Name: "dns", Retryable: true, CreationStep: "made in ip", Run: provisionDNSStep},
+}
+
+// a rebuild deploys a new VM next to the old one and then swaps them, the IP, DNS records and firewall stay the same
+var rebuildSteps = []provisioningStep{
+	{Name: "vcenter", Retryable: true, Run: provisionVCenterStep},
+	{Name: "swap", Retryable: true, Run: rebuildSwapStep},
+	{Name: "power_on", Retryable: true, Run: provisionPowerOnStep},
+	{Name: "start_script", Retryable: false, Run: provisionStartScriptStep},
+}
+
+// provisioningJobSlots limits how many jobs talk to vCenter at the same time, jobs wait as pending for a free slot,
+// without this a bulk creation of a whole class would deploy all servers at once
+var provisioningJobSlots = make(chan struct{}, max(1, getIntEnvVar("MAX_CONCURRENT_PROVISIONING_JOBS", 10)))
+
+// the new VM of a rebuild gets this after its name until the old VM is gone
+const rebuildVMSuffix = "-rebuild"
+
+func provisioningStepsForJob(kind string) []provisioningStep {
+	if kind == "rebuild" {
+		return rebuildSteps
+	}
+
+	return provisioningSteps
+}
+
+// createProvisioningJob stores the job and all of its steps, the db step is already done because the server row exists
+func createProvisioningJob(db *sql.DB, job *provisioningJob) (int64, error) {
+	request, err := jsonMarshalString(job.Request)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO provisioning_jobs (kind, users_id, student_id, full_name, server_name, request, ip, ipv6, server_id, source_vcenter_id, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending')",
+		job.Kind, job.UsersId, job.StudentID, job.FullName, job.Request.Name, request, job.IP, job.IPv6, job.ServerId, job.SourceVcenterId)
+	if err != nil {
+		return 0, err
+	}
+
+	jobID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for position, step := range provisioningStepsForJob(job.Kind) {
+		status := "pending"
+		if step.Name == "db" {
+			status = "done"
+		}
+
+		_, err = tx.Exec("INSERT INTO provisioning_job_steps (job_id, step, position, status) VALUES (?, ?, ?, ?)", jobID, step.Name, position, status)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return jobID, tx.Commit()
+}
+
+func getProvisioningJob(db *sql.DB, jobID int64) (*provisioningJob, error) {
+	var (
+		job     provisioningJob
+		request string
+	)
+
+	err := db.QueryRow("SELECT id, kind, users_id, student_id, full_name, server_name, request, ip, ipv6, vcenter_id, COALESCE(server_id, 0), status, source_vcenter_id FROM provisioning_jobs WHERE id = ?", jobID).
+		Scan(&job.ID, &job.Kind, &job.UsersId, &job.StudentID, &job.FullName, &job.ServerName, &request, &job.IP, &job.IPv6, &job.VcenterId, &job.ServerId, &job.Status, &job.SourceVcenterId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(request), &job.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// getProvisioningJobStepStatuses returns the status of every step of the job keyed by the step name
+func getProvisioningJobStepStatuses(db *sql.DB, jobID int64) (map[string]string, error) {
+	rows, err := db.Query("SELECT step, status FROM provisioning_job_steps WHERE job_id = ?", jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var step, status string
+		err = rows.Scan(&step, &status)
+		if err != nil {
+			return nil, err
+		}
+		statuses[step] = status
+	}
+
+	return statuses, nil
+}
+
+func setProvisioningJobStatus(db *sql.DB, jobID int64, status string) {
+	_, err := db.Exec("UPDATE provisioning_jobs SET status = ? WHERE id = ?", status, jobID)
+	if err != nil {
+		log.Println("Error updating provisioning job status: ", err)
+	}
+}
+
+func setProvisioningStepStatus(db *sql.DB, jobID int64, step, status string, stepErr error) {
+	var err error
+	switch status {
+	case "running":
+		_, err = db.Exec("UPDATE provisioning_job_steps SET status = ?, error = NULL, started_at = NOW(), finished_at = NULL WHERE job_id = ? AND step = ?", status, jobID, step)
+	case "failed":
+		_, err = db.Exec("UPDATE provisioning_job_steps SET status = ?, error = ?, finished_at = NOW() WHERE job_id = ? AND step = ?", status, stepErr.Error(), jobID, step)
+	default:
+		_, err = db.Exec("UPDATE provisioning_job_steps SET status = ?, finished_at = NOW() WHERE job_id = ? AND step = ?", status, jobID, step)
+	}
+	if err != nil {
+		log.Println("Error updating provisioning step status: ", err)
+	}
+}
+
+// runProvisioningJob walks through all the steps of the job that are not done yet,
+// this is also used to pick up jobs that were still running when the API was stopped
+func runProvisioningJob(jobID int64) {
+	provisioningJobSlots <- struct{}{}
+	defer func() { <-provisioningJobSlots }()
+
+	defer timeTrack(time.Now(), "runProvisioningJob")
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return
+	}
+	defer db.Close()
+
+	job, err := getProvisioningJob(db, jobID)
+	if err != nil {
+		log.Println("Error fetching provisioning job: ", err)
+		return
+	}
+
+	statuses, err := getProvisioningJobStepStatuses(db, jobID)
+	if err != nil {
+		log.Println("Error fetching provisioning job steps: ", err)
+		return
+	}
+
+	setProvisioningJobStatus(db, jobID, "running")
+
+	lastDoneStep := ""
+	for _, step := range provisioningStepsForJob(job.Kind) {
+		if statuses[step.Name] == "done" {
+			lastDoneStep = step.CreationStep
+			continue
+		}
+
+		if statuses[step.Name] == "running" && !step.Retryable {
+			err = fmt.Errorf("step %s was interrupted and can't be retried", step.Name)
+			setProvisioningStepStatus(db, jobID, step.Name, "failed", err)
+			failProvisioningJob(job, step.CreationStep, err, db)
+			return
+		}
+
+		setProvisioningStepStatus(db, jobID, step.Name, "running", nil)
+
+		err = step.Run(job, db)
+		if err != nil {
+			logErrorInDB(err)
+			log.Println("Error in provisioning step "+step.Name+": ", err)
+			setProvisioningStepStatus(db, jobID, step.Name, "failed", err)
+			failProvisioningJob(job, lastDoneStep, err, db)
+			return
+		}
+
+		setProvisioningStepStatus(db, jobID, step.Name, "done", nil)
+		lastDoneStep = step.CreationStep
+	}
+
+	setProvisioningJobStatus(db, jobID, "done")
+	if job.Kind == "rebuild" {
+		notifyRebuildJobDone(job, db)
+		return
+	}
+	notifyProvisioningJobDone(job, db)
+}
+
+// failProvisioningJob runs the compensation for everything that was made before the failing step
+func failProvisioningJob(job *provisioningJob, serverCreationStep string, err error, db *sql.DB) {
+	log.Println("Provisioning job ", job.ID, " failed: ", err)
+	// the vcenter step can fail after the VM was made, that VM has to be removed as well
+	if serverCreationStep == "made in db" && job.VcenterId != "" {
+		serverCreationStep = "made in vCenter"
+	}
+
+	if job.Kind == "rebuild" {
+		failRebuildJob(job, db)
+	} else {
+		handleFailedCreation(job.ServerName, job.UsersId, job.StudentID, job.VcenterId, serverCreationStep, job.IP, job.IPv6, db)
+	}
+	setProvisioningJobStatus(db, job.ID, "failed")
+}
+
+// resumeProvisioningJobs picks up the jobs that were still pending or running when the API was stopped
+func resumeProvisioningJobs() {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id FROM provisioning_jobs WHERE status IN ('pending', 'running')")
+	if err != nil {
+		log.Println("Error fetching unfinished provisioning jobs: ", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobID int64
+		err = rows.Scan(&jobID)
+		if err != nil {
+			log.Println("Error scanning provisioning job: ", err)
+			continue
+		}
+
+		log.Println("Resuming provisioning job: ", jobID)
+		go runProvisioningJob(jobID)
+	}
+}
+
+func provisionVCenterStep(job *provisioningJob, db *sql.DB) error {
+	ctx := context.Background()
+
+	if job.VcenterId == "" {
+		network, err := getNetworkByName(db, provisioningJobNetwork(job))
+		if err != nil {
+			return err
+		}
+
+		vmName := job.ServerName
+		if job.Kind == "rebuild" {
+			vmName += rebuildVMSuffix
+		}
+
+		password, err := getInitialPassword(job.ServerId)
+		if err != nil {
+			return err
+		}
+
+		customization := getGuestCustomization(job.Request.OperatingSystem, job.ServerName, job.StudentID, job.IP, job.IPv6, network, getAuthorizedKeysForUser(db, job.UsersId), password)
+
+		var vCenterID string
+		if job.Kind == "clone" {
+			// CloneServer already checked this, but a data disk could have been added while the job was waiting
+			var disks int
+			err = db.QueryRow("SELECT COUNT(*) FROM server_disks d JOIN virtual_machines v ON d.virtual_machines_id = v.id WHERE v.vcenter_id = ?", job.SourceVcenterId).Scan(&disks)
+			if err != nil {
+				return err
+			}
+			if disks > 0 {
+				return fmt.Errorf("the source server has data disks, those can't be cloned")
+			}
+
+			// the start script still logs in with the user of the template, so the source has to keep that user
+			vCenterID, err = clonevCenterVM(ctx, job.StudentID, vmName, job.SourceVcenterId, customization)
+		} else {
+			vCenterID, err = createvCenterVM(ctx, job.StudentID, vmName, job.Request.OperatingSystem, network.VcenterNetwork, job.Request.Cpu, job.Request.Storage, job.Request.Memory, customization)
+		}
+		if isVCenterError(err, "ALREADY_EXISTS") {
+			// the VM was deployed before the API was stopped, so we just have to find it again,
+			// it was never powered on so the customization can still be set
+			vCenterID, err = getvCenterVMIDByName(ctx, "OICT-AUTO-"+job.StudentID+"-"+vmName)
+			if err == nil && vCenterID != "" && customization != nil {
+				err = setvCenterGuestCustomization(ctx, vCenterID, customization)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if vCenterID == "" {
+			return fmt.Errorf("vCenter did not return a VM ID")
+		}
+
+		job.VcenterId = vCenterID
+		_, err = db.Exec("UPDATE provisioning_jobs SET vcenter_id = ? WHERE id = ?", vCenterID, job.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the server keeps the old VM until the swap step
+	if job.Kind == "rebuild" {
+		return nil
+	}
+
+	return updateServerWithVCenterID(job.VcenterId, job.ServerName, job.UsersId, job.IP, job.IPv6, db)
+}
+
+func provisionSophosStep(job *provisioningJob, db *sql.DB) error {
+	network, err := getNetworkByName(db, provisioningJobNetwork(job))
+	if err != nil {
+		return err
+	}
+
+	return createFirewallRuleForServerCreation(job.IP, job.IPv6, job.StudentID, job.ServerName, network.SophosZone)
+}
+
+func provisionIpStep(job *provisioningJob, db *sql.DB) error {
+	err := assignIPToVM(job.IP, job.VcenterId)
+	if err != nil {
+		return err
+	}
+
+	if job.IPv6 != "" {
+		err = assignIPToVM(job.IPv6, job.VcenterId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return addUsersToFirewall(job.StudentID, job.Request)
+}
+
+func provisionPowerOnStep(job *provisioningJob, db *sql.DB) error {
+	return powerOn(context.Background(), job.VcenterId)
+}
+
+func provisionStartScriptStep(job *provisioningJob, db *sql.DB) error {
+	startScript, err := readStartScript(job.Request.OperatingSystem)
+	if err != nil {
+		return err
+	}
+
+	// the guest customization already set up the server, there are no template credentials to run a script with
+	if startScript.Customization != nil && startScript.ScriptExecutable == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	// the VM was just powered on, the guest can't run the script before it is booted
+	err = waitForGuestTools(ctx, job.VcenterId)
+	if err != nil {
+		return err
+	}
+
+	password, err := getInitialPassword(job.ServerId)
+	if err != nil {
+		return err
+	}
+
+	result, err := runStartScript(ctx, startScript, provisioningJobFirstName(job), password, job.StudentID, job.VcenterId, job.IP, job.IPv6, job.ServerName, getAuthorizedKeysForUser(db, job.UsersId))
+	if err != nil {
+		return err
+	}
+
+	log.Println("Start script of server ", job.ServerName, " exited with ", result.ExitCode, ": ", result.Output)
+	if result.ExitCode != 0 {
+		// the end of the output is where the script stopped, it is stored as the error of the step
+		output := result.Output
+		if len(output) > 2000 {
+			output = output[len(output)-2000:]
+		}
+		return fmt.Errorf("start script exited with code %d: %s", result.ExitCode, output)
+	}
+
+	return nil
+}
+
+func provisionDNSStep(job *provisioningJob, db *sql.DB) error {
+	if job.Request.SubDomain == nil || job.Request.DomainZone == nil {
+		return nil
+	}
+
+	// convert the subdomain and domain zone to regular strings
+	zone := *job.Request.DomainZone
+	subdomain := *job.Request.SubDomain + "." + getEnvVar("SUBDOMAIN_PREFIX")
+
+	// strip any dots from the subdomain to make sure it's a parent
+	subdomain = strings.TrimSuffix(subdomain, ".")
+
+	serverID := strconv.FormatInt(job.ServerId, 10)
+
+	// the record was already made before the API was stopped
+	if !checkIfUserAlreadyHasRecordInDB(db, zone, subdomain, "A", job.IP) {
+		err := createDNSRecord(db, subdomain, zone, job.IP, strconv.Itoa(3306), "A", serverID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if job.IPv6 != "" && !checkIfUserAlreadyHasRecordInDB(db, zone, subdomain, "AAAA", job.IPv6) {
+		return createDNSRecord(db, subdomain, zone, job.IPv6, strconv.Itoa(3306), "AAAA", serverID)
+	}
+
+	return nil
+}
+
+func notifyProvisioningJobDone(job *provisioningJob, db *sql.DB) {
+	_, _, _, studentEmail, err := fetchUserInfoWithSID(job.UsersId)
+	if err != nil {
+		log.Println("Error fetching user info: ", err)
+	}
+
+	serverCreationSuccessTitle := "Server is gemaakt"
+	ips := job.IP
+	if job.IPv6 != "" {
+		ips += " en " + job.IPv6
+	}
+
+	serverCreationSuccessBody := "Je server(" + job.ServerName + ") is gemaakt met het ip: " + ips + ". " + serverLoginMessage(job, db) + " Als je een subdomein hebt aangevraagd dan is deze ook aangemaakt kan je deze nu ook gebruiken."
+	// check if the email is not empty
+	createNotificationForUser(db, job.UsersId, serverCreationSuccessTitle, serverCreationSuccessBody)
+	if studentEmail != "" {
+		sendEmailNotification(studentEmail, serverCreationSuccessTitle, serverCreationSuccessBody)
+	}
+}
+
+// serverLoginMessage tells the owner how to log in on the new server, the password itself is never put in a
+// notification or email, the owner can see it once with ViewInitialPassword
+func serverLoginMessage(job *provisioningJob, db *sql.DB) string {
+	userName := provisioningJobFirstName(job)
+
+	// cloud-init makes its own user
+	script, err := readStartScript(job.Request.OperatingSystem)
+	cloudInit := err == nil && script.Customization != nil && script.Customization.Type == "cloud-init"
+	if cloudInit {
+		userName = guestUserName(*script.Customization, job.StudentID)
+	}
+
+	message := "Je gebruikersnaam is: " + userName + ", je wachtwoord kan je één keer bekijken bij je server en moet je veranderen als je de eerste keer inlogt."
+	// only cloud-init is known to add the keys and turn off password login, for start scripts it depends on the script
+	if cloudInit && len(getAuthorizedKeysForUser(db, job.UsersId)) > 0 {
+		message += " Via SSH kan je alleen inloggen met je SSH key."
+	}
+
+	return message
+}
+
+// jobs made before networks existed don't have one, so they use the default network
+func provisioningJobNetwork(job *provisioningJob) string {
+	if job.Request.Network == nil {
+		return ""
+	}
+	return *job.Request.Network
+}
+
+// Get only the first name of the user
+func provisioningJobFirstName(job *provisioningJob) string {
+	return strings.Split(job.FullName, " ")[0]
+}
+
+func jsonMarshalString(v interface{}) (string, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"time"
+)
+
+type ProvisioningJobStep struct {
+	Step       string  `json:"step"`
+	Status     string  `json:"status"`
+	Error      *string `json:"error"`
+	StartedAt  *string `json:"started_at"`
+	FinishedAt *string `json:"finished_at"`
+}
+
+type ProvisioningJobStatus struct {
+	ID         int64                 `json:"id"`
+	Kind       string                `json:"kind"`
+	ServerID   *int64                `json:"server_id"`
+	ServerName string                `json:"server_name"`
+	Status     string                `json:"status"`
+	CreatedAt  string                `json:"created_at"`
+	UpdatedAt  string                `json:"updated_at"`
+	Steps      []ProvisioningJobStep `json:"steps"`
+}
+
+func GetProvisioningJob(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	userId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+
+	job, err := getProvisioningJobStatus(db, c.Param("id"), userId, isAdmin)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "Can't find job with that ID")
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// how long a ticket for the events of a job can be used to (re)connect
+const jobEventsTicketTTL = time.Minute
+
+// jobEventsTicket is who a ticket was given to, it is stored in Redis under the ticket
+type jobEventsTicket struct {
+	JobID   string `json:"job_id"`
+	UsersId string `json:"users_id"`
+	IsAdmin bool   `json:"is_admin"`
+}
+
+func jobEventsTicketKey(ticket string) string {
+	return "job_events_ticket:" + ticket
+}
+
+// CreateProvisioningJobTicket swaps the JWT for a ticket to the events of the job, the EventSource of a browser
+// can't send the Authorization header so the ticket is put in the URL instead. It only works for this job
+// and expires after a minute, so it doesn't matter much when it ends up in a log
+func CreateProvisioningJobTicket(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	userId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+
+	job, err := getProvisioningJobStatus(db, c.Param("id"), userId, isAdmin)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "Can't find job with that ID")
+	}
+
+	random := make([]byte, 32)
+	_, err = rand.Read(random)
+	if err != nil {
+		log.Println("Error making ticket: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	ticket := hex.EncodeToString(random)
+
+	value, err := json.Marshal(jobEventsTicket{JobID: fmt.Sprint(job.ID), UsersId: userId, IsAdmin: isAdmin})
+	if err != nil {
+		return err
+	}
+
+	_, err = setToRedisIfNotExists(jobEventsTicketKey(ticket), string(value), jobEventsTicketTTL)
+	if err != nil {
+		log.Println("Error storing ticket: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(jobEventsTicketTTL.Seconds()),
+	})
+}
+
+// StreamProvisioningJob sends the status of the job as Server-Sent Events every time it changes,
+// the stream is closed once the job is done or failed. It is not behind checkIfLoggedIn, the ticket of
+// CreateProvisioningJobTicket is sent as ?ticket= and can be used again until it expires, so the
+// EventSource can reconnect by itself
+func StreamProvisioningJob(c echo.Context) error {
+	id := c.Param("id")
+
+	var ticket jobEventsTicket
+	value := getFromRedis(jobEventsTicketKey(c.QueryParam("ticket")))
+	if c.QueryParam("ticket") == "" || value == "" || json.Unmarshal([]byte(value), &ticket) != nil || ticket.JobID != id {
+		return c.JSON(http.StatusUnauthorized, "Invalid or expired ticket")
+	}
+	userId, isAdmin := ticket.UsersId, ticket.IsAdmin
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	job, err := getProvisioningJobStatus(db, id, userId, isAdmin)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "Can't find job with that ID")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastEvent := ""
+	for {
+		event, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+
+		if string(event) != lastEvent {
+			_, err = fmt.Fprintf(res, "data: %s\n\n", event)
+			if err != nil {
+				return nil
+			}
+			res.Flush()
+			lastEvent = string(event)
+		}
+
+		if job.Status == "done" || job.Status == "failed" {
+			return nil
+		}
+
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		job, err = getProvisioningJobStatus(db, id, userId, isAdmin)
+		if err != nil {
+			log.Println("Error fetching provisioning job: ", err)
+			return nil
+		}
+	}
+}
+
+func getProvisioningJobStatus(db *sql.DB, id, userId string, isAdmin bool) (ProvisioningJobStatus, error) {
+	var job ProvisioningJobStatus
+	var serverID sql.NullInt64
+
+	query := "SELECT id, kind, server_id, server_name, status, created_at, updated_at FROM provisioning_jobs WHERE id = ?"
+	args := []interface{}{id}
+	if !isAdmin {
+		query += " AND users_id = ?"
+		args = append(args, userId)
+	}
+
+	err := db.QueryRow(query, args...).Scan(&job.ID, &job.Kind, &serverID, &job.ServerName, &job.Status, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return job, err
+	}
+
+	if serverID.Valid {
+		job.ServerID = &serverID.Int64
+	}
+
+	rows, err := db.Query("SELECT step, status, error, started_at, finished_at FROM provisioning_job_steps WHERE job_id = ? ORDER BY position", job.ID)
+	if err != nil {
+		return job, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			step                           ProvisioningJobStep
+			stepErr, startedAt, finishedAt sql.NullString
+		)
+		err = rows.Scan(&step.Step, &step.Status, &stepErr, &startedAt, &finishedAt)
+		if err != nil {
+			return job, err
+		}
+
+		step.Error = nullStringToPointer(stepErr)
+		step.StartedAt = nullStringToPointer(startedAt)
+		step.FinishedAt = nullStringToPointer(finishedAt)
+		job.Steps = append(job.Steps, step)
+	}
+
+	return job, nil
+}
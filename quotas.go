@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Quota is used both for the limits and for the usage of a user, memory and storage are the totals in GB
+type Quota struct {
+	Servers    int `json:"servers"`
+	Memory     int `json:"memory"`
+	Storage    int `json:"storage"`
+	DNSRecords int `json:"dns_records"`
+}
+
+type quotaJsonBody struct {
+	// user or group
+	SubjectType string `json:"subject_type"`
+	// the SID of the user or the CN of the LDAP group
+	Subject string `json:"subject"`
+	Quota
+}
+
+type QuotaRule struct {
+	ID          int64  `json:"id"`
+	SubjectType string `json:"subject_type"`
+	Subject     string `json:"subject"`
+	Quota
+}
+
+// quotaQueryer is a *sql.DB or the *sql.Tx of lockQuota
+type quotaQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func defaultQuota() Quota {
+	return Quota{
+		Servers:    getIntEnvVar("DEFAULT_QUOTA_SERVERS", 3),
+		Memory:     getIntEnvVar("DEFAULT_QUOTA_MEMORY", 6),
+		Storage:    getIntEnvVar("DEFAULT_QUOTA_STORAGE", 60),
+		DNSRecords: getIntEnvVar("DEFAULT_QUOTA_DNS_RECORDS", 10),
+	}
+}
+
+// getQuotaForUser returns the quota of the user itself, otherwise the most generous one of the groups of the user,
+// otherwise the default quota
+func getQuotaForUser(db quotaQueryer, sid string) (Quota, error) {
+	var quota Quota
+	err := db.QueryRow("SELECT max_servers, max_memory, max_storage, max_dns_records FROM quotas WHERE subject_type = 'user' AND subject = ?", sid).
+		Scan(&quota.Servers, &quota.Memory, &quota.Storage, &quota.DNSRecords)
+	if err == nil {
+		return quota, nil
+	}
+	if err != sql.ErrNoRows {
+		return Quota{}, err
+	}
+
+	groups, err := fetchGroupsWithSID(sid)
+	if err != nil {
+		log.Println("Error fetching groups of user, using the default quota: ", err)
+		return defaultQuota(), nil
+	}
+	if len(groups) == 0 {
+		return defaultQuota(), nil
+	}
+
+	args := make([]interface{}, len(groups))
+	for i, group := range groups {
+		args[i] = group
+	}
+
+	var found bool
+	err = db.QueryRow(`SELECT COUNT(*) > 0, COALESCE(MAX(max_servers), 0), COALESCE(MAX(max_memory), 0), COALESCE(MAX(max_storage), 0), COALESCE(MAX(max_dns_records), 0)
+		FROM quotas WHERE subject_type = 'group' AND subject IN (?`+strings.Repeat(", ?", len(groups)-1)+`)`, args...).
+		Scan(&found, &quota.Servers, &quota.Memory, &quota.Storage, &quota.DNSRecords)
+	if err != nil {
+		return Quota{}, err
+	}
+	if !found {
+		return defaultQuota(), nil
+	}
+
+	return quota, nil
+}
+
+// getQuotaUsage counts what the user is using right now, deleted servers that can still be restored are not counted.
+// The storage is the root disks together with the data disks, a subdomain with both an A and an AAAA record is one DNS record
+func getQuotaUsage(db quotaQueryer, sid string) (Quota, error) {
+	var usage Quota
+	err := db.QueryRow("SELECT COUNT(*), COALESCE(SUM(memory), 0), COALESCE(SUM(storage), 0) FROM virtual_machines WHERE users_id = ? AND deleted_at IS NULL", sid).
+		Scan(&usage.Servers, &usage.Memory, &usage.Storage)
+	if err != nil {
+		return Quota{}, err
+	}
+
+	var dataDiskStorage int
+	err = db.QueryRow(`SELECT COALESCE(SUM(d.capacity), 0) FROM server_disks d JOIN virtual_machines vm ON d.virtual_machines_id = vm.id
+		WHERE vm.users_id = ? AND vm.deleted_at IS NULL`, sid).Scan(&dataDiskStorage)
+	if err != nil {
+		return Quota{}, err
+	}
+	usage.Storage += dataDiskStorage
+
+	err = db.QueryRow(`SELECT COUNT(DISTINCT s.parent_domain, s.subDomain) FROM sub_domains s JOIN virtual_machines vm ON s.virtual_machines_id = vm.id
+		WHERE vm.users_id = ? AND vm.deleted_at IS NULL`, sid).Scan(&usage.DNSRecords)
+	if err != nil {
+		return Quota{}, err
+	}
+
+	return usage, nil
+}
+
+// checkQuota checks if the user can use extra on top of what they are already using,
+// the returned message tells the user what they are over on and is empty when they are within their quota.
+// Use the transaction of lockQuota, otherwise two requests can both fit in the quota that only has room for one
+func checkQuota(db quotaQueryer, sid string, extra Quota) (string, error) {
+	quota, err := getQuotaForUser(db, sid)
+	if err != nil {
+		return "", err
+	}
+
+	usage, err := getQuotaUsage(db, sid)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case extra.Servers > 0 && usage.Servers+extra.Servers > quota.Servers:
+		return fmt.Sprintf("You already have %d servers, you can't create more", usage.Servers), nil
+	case extra.Memory > 0 && usage.Memory+extra.Memory > quota.Memory:
+		return fmt.Sprintf("Not enough memory left in your quota, you have %d GB left", max(quota.Memory-usage.Memory, 0)), nil
+	case extra.Storage > 0 && usage.Storage+extra.Storage > quota.Storage:
+		return fmt.Sprintf("Not enough storage left in your quota, you have %d GB left", max(quota.Storage-usage.Storage, 0)), nil
+	case extra.DNSRecords > 0 && usage.DNSRecords+extra.DNSRecords > quota.DNSRecords:
+		return fmt.Sprintf("You already have %d DNS records, you can't create more", usage.DNSRecords), nil
+	}
+
+	return "", nil
+}
+
+// lockQuota makes the requests of one user that use their quota wait on each other. The quota has to be checked in the
+// returned transaction and it has to be rolled back or committed after the change that uses the quota is saved
+func lockQuota(db *sql.DB, sid string) (*sql.Tx, error) {
+	// the row is made outside the transaction, two requests that both make it would otherwise deadlock
+	_, err := db.Exec("INSERT IGNORE INTO quota_locks (users_id) VALUES (?)", sid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var locked string
+	err = tx.QueryRow("SELECT users_id FROM quota_locks WHERE users_id = ? FOR UPDATE", sid).Scan(&locked)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
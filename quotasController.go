@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+func GetMyQuota(c echo.Context) error {
+	userId, _, _, _ := getUserAssociatedWithJWT(c)
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	quota, err := getQuotaForUser(db, userId)
+	if err != nil {
+		log.Println("Error fetching quota: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to fetch quota")
+	}
+
+	usage, err := getQuotaUsage(db, userId)
+	if err != nil {
+		log.Println("Error fetching quota usage: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to fetch quota")
+	}
+
+	return c.JSON(http.StatusOK, map[string]Quota{"quota": quota, "usage": usage})
+}
+
+func GetQuotas(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, subject_type, subject, max_servers, max_memory, max_storage, max_dns_records FROM quotas ORDER BY subject_type, subject")
+	if err != nil {
+		log.Println("Error fetching quotas: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to fetch quotas")
+	}
+	defer rows.Close()
+
+	quotas := []QuotaRule{}
+	for rows.Next() {
+		var quota QuotaRule
+		err = rows.Scan(&quota.ID, &quota.SubjectType, &quota.Subject, &quota.Servers, &quota.Memory, &quota.Storage, &quota.DNSRecords)
+		if err != nil {
+			log.Println("Error scanning quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to fetch quotas")
+		}
+		quotas = append(quotas, quota)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"default": defaultQuota(), "quotas": quotas})
+}
+
+// SetQuota creates the quota of a user or group, or replaces it when it already exists
+func SetQuota(c echo.Context) error {
+	var jsonBody quotaJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid request")
+	}
+
+	if jsonBody.SubjectType != "user" && jsonBody.SubjectType != "group" {
+		return c.JSON(http.StatusBadRequest, "subject_type has to be user or group")
+	}
+
+	if jsonBody.Subject == "" {
+		return c.JSON(http.StatusBadRequest, "A quota needs a subject")
+	}
+
+	if jsonBody.Servers < 0 || jsonBody.Memory < 0 || jsonBody.Storage < 0 || jsonBody.DNSRecords < 0 {
+		return c.JSON(http.StatusBadRequest, "A quota can't be negative")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO quotas (subject_type, subject, max_servers, max_memory, max_storage, max_dns_records) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE max_servers = VALUES(max_servers), max_memory = VALUES(max_memory), max_storage = VALUES(max_storage),
+		max_dns_records = VALUES(max_dns_records), updated_at = NOW()`,
+		jsonBody.SubjectType, jsonBody.Subject, jsonBody.Servers, jsonBody.Memory, jsonBody.Storage, jsonBody.DNSRecords)
+	if err != nil {
+		log.Println("Error saving quota: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to save quota")
+	}
+
+	return c.JSON(http.StatusOK, "Quota saved")
+}
+
+func DeleteQuota(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM quotas WHERE id = ?", c.Param("id"))
+	if err != nil {
+		log.Println("Error deleting quota: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to delete quota")
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return c.JSON(http.StatusNotFound, "Quota not found")
+	}
+
+	return c.JSON(http.StatusOK, "Quota deleted")
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// the kinds of drift the reconciler looks for, every kind can be repaired on its own
+const (
+	// a VM in vCenter that has no server in the database
+	driftOrphanedVM = "orphaned_vm"
+	// a server in the database that has no VM in vCenter, repairing it deletes the server so the purge task cleans up the rest
+	driftMissingVM = "missing_vm"
+	// an IP that is still claimed while no server is being made with it
+	driftStaleIpClaim = "stale_ip_claim"
+	// an IP that is assigned to a VM that has no server in the database
+	driftOrphanedIp = "orphaned_ip"
+	// an IP host in Sophos that has no server in the database
+	driftOrphanedSophosHost = "orphaned_sophos_host"
+	// a DNS record in the database of a server that no longer exists
+	driftOrphanedDNSRecord = "orphaned_dns_record"
+	// a DNS record in the database that is not in Technitium, repairing it makes the record again
+	driftMissingDNSRecord = "missing_dns_record"
+	// a record in Technitium below the subdomain prefix that is not in the database
+	driftUnknownDNSRecord = "unknown_dns_record"
+)
+
+var driftClasses = []string{driftOrphanedVM, driftMissingVM, driftStaleIpClaim, driftOrphanedIp, driftOrphanedSophosHost, driftOrphanedDNSRecord,
+	driftMissingDNSRecord, driftUnknownDNSRecord}
+
+type Drift struct {
+	Class string `json:"class"`
+	// vcenter, database, ip_pool, sophos or dns
+	Source   string `json:"source"`
+	Object   string `json:"object"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+
+	repair func() error
+}
+
+type ReconcileReport struct {
+	Drifts []Drift `json:"drifts"`
+	// the sources that could not be read, the drift of those sources is missing from the report
+	Errors []string `json:"errors"`
+}
+
+type reconcileJsonBody struct {
+	Repair []string `json:"repair"`
+}
+
+// reconcileState is what the database says should exist, soft deleted servers still have all their resources
+type reconcileState struct {
+	servers    []reconcileServer
+	vcenterIDs map[string]bool
+	// the VMs and IPs of servers that are still being made
+	jobVMNames map[string]bool
+	jobIps     map[string]bool
+	// Sophos IP host names, when the owner of a server can't be found only the server name is known
+	sophosHosts       map[string]bool
+	unknownOwnerNames []string
+}
+
+type reconcileServer struct {
+	id                                        int
+	usersId, studentId, vcenterId, name, ipv6 string
+	deleted                                   bool
+}
+
+func GetReconcileReport(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	report, err := reconcile(db, nil)
+	if err != nil {
+		log.Println("Error reconciling: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error reconciling")
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+func RepairDrift(c echo.Context) error {
+	var jsonBody reconcileJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid request")
+	}
+
+	repair := make(map[string]bool)
+	for _, class := range jsonBody.Repair {
+		if !checkIfItemIsKeyOfArray(class, driftClasses) {
+			return c.JSON(http.StatusBadRequest, "Unknown drift class "+class)
+		}
+		repair[class] = true
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	report, err := reconcile(db, repair)
+	if err != nil {
+		log.Println("Error reconciling: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error reconciling")
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// reconcileServers is run by the scheduler, it only repairs the drift classes in RECONCILE_REPAIR
+func reconcileServers() {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return
+	}
+	defer db.Close()
+
+	repair := make(map[string]bool)
+	for _, class := range strings.Split(getEnvVar("RECONCILE_REPAIR"), ",") {
+		class = strings.TrimSpace(class)
+		if class != "" {
+			repair[class] = true
+		}
+	}
+
+	report, err := reconcile(db, repair)
+	if err != nil {
+		log.Println("Error reconciling: ", err)
+		return
+	}
+
+	for _, drift := range report.Drifts {
+		log.Println("Drift ", drift.Class, " in ", drift.Source, ": ", drift.Object, " ", drift.Detail, " repaired: ", drift.Repaired)
+		if drift.Error != "" {
+			logErrorInDB(fmt.Errorf("repairing %s %s failed: %s", drift.Class, drift.Object, drift.Error))
+		}
+	}
+	for _, sourceErr := range report.Errors {
+		log.Println("Error reconciling: ", sourceErr)
+	}
+}
+
+func reconcileInterval() time.Duration {
+	return time.Duration(getIntEnvVar("RECONCILE_INTERVAL_MINUTES", 1440)) * time.Minute
+}
+
+// reconcile compares the database with vCenter, the IP pool, Sophos and the DNS and repairs the drift classes in repair
+func reconcile(db *sql.DB, repair map[string]bool) (ReconcileReport, error) {
+	report := ReconcileReport{Drifts: []Drift{}, Errors: []string{}}
+
+	state, err := loadReconcileState(db)
+	if err != nil {
+		return report, err
+	}
+
+	sources := []struct {
+		name string
+		find func(db *sql.DB, state *reconcileState) ([]Drift, error)
+	}{
+		{"vcenter", findVCenterDrift},
+		{"ip_pool", findIpDrift},
+		{"sophos", findSophosDrift},
+		{"dns", findDNSDrift},
+	}
+
+	for _, source := range sources {
+		drifts, err := source.find(db, state)
+		if err != nil {
+			report.Errors = append(report.Errors, source.name+": "+err.Error())
+			continue
+		}
+
+		for _, drift := range drifts {
+			if repair[drift.Class] {
+				err = drift.repair()
+				if err != nil {
+					drift.Error = err.Error()
+				} else {
+					drift.Repaired = true
+				}
+			}
+			report.Drifts = append(report.Drifts, drift)
+		}
+	}
+
+	return report, nil
+}
+
+func loadReconcileState(db *sql.DB) (*reconcileState, error) {
+	state := &reconcileState{
+		vcenterIDs:  make(map[string]bool),
+		jobVMNames:  make(map[string]bool),
+		jobIps:      make(map[string]bool),
+		sophosHosts: make(map[string]bool),
+	}
+
+	rows, err := db.Query("SELECT id, users_id, student_id, vcenter_id, name, ipv6, deleted_at IS NOT NULL FROM virtual_machines")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var server reconcileServer
+		err = rows.Scan(&server.id, &server.usersId, &server.studentId, &server.vcenterId, &server.name, &server.ipv6, &server.deleted)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		state.servers = append(state.servers, server)
+		if server.vcenterId != "" {
+			state.vcenterIDs[server.vcenterId] = true
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT kind, student_id, server_name, ip, ipv6, vcenter_id FROM provisioning_jobs WHERE status IN ('pending', 'running')")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var kind, studentID, serverName, ip, ipv6, vcenterId string
+		err = rows.Scan(&kind, &studentID, &serverName, &ip, &ipv6, &vcenterId)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		state.jobVMNames["OICT-AUTO-"+studentID+"-"+serverName] = true
+		if kind == "rebuild" {
+			state.jobVMNames["OICT-AUTO-"+studentID+"-"+serverName+rebuildVMSuffix] = true
+		}
+		state.jobIps[ip] = true
+		if ipv6 != "" {
+			state.jobIps[ipv6] = true
+		}
+		if vcenterId != "" {
+			state.vcenterIDs[vcenterId] = true
+		}
+		state.sophosHosts["OICT-AUTO-HOST-"+studentID+"-"+serverName] = true
+		state.sophosHosts["OICT-AUTO-HOST6-"+studentID+"-"+serverName] = true
+	}
+	rows.Close()
+
+	// the Sophos objects are named after the student ID of the owner, servers made before it was stored need LDAP
+	studentIDs := make(map[string]string)
+	for _, server := range state.servers {
+		studentID, ok := server.studentId, server.studentId != ""
+		if !ok {
+			studentID, ok = studentIDs[server.usersId]
+		}
+		if !ok {
+			studentID, err = getStudentIDWithSID(server.usersId)
+			if err != nil {
+				log.Println("Error fetching owner of server: ", err)
+			}
+			studentIDs[server.usersId] = studentID
+		}
+
+		if studentID == "" {
+			state.unknownOwnerNames = append(state.unknownOwnerNames, server.name)
+			continue
+		}
+
+		state.sophosHosts["OICT-AUTO-HOST-"+studentID+"-"+server.name] = true
+		if server.ipv6 != "" {
+			state.sophosHosts["OICT-AUTO-HOST6-"+studentID+"-"+server.name] = true
+		}
+	}
+
+	return state, nil
+}
+
+func findVCenterDrift(db *sql.DB, state *reconcileState) ([]Drift, error) {
+	vms, err := listvCenterVMs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+	inVCenter := make(map[string]bool)
+	for _, vm := range vms {
+		inVCenter[vm.Vm] = true
+
+		if !strings.HasPrefix(vm.Name, "OICT-AUTO-") || state.vcenterIDs[vm.Vm] || state.jobVMNames[vm.Name] {
+			continue
+		}
+
+		vmID := vm.Vm
+		drifts = append(drifts, Drift{
+			Class:  driftOrphanedVM,
+			Source: "vcenter",
+			Object: vm.Name,
+			Detail: "VM " + vmID + " has no server in the database",
+			repair: func() error {
+				return deletevCenterVM(context.Background(), vmID)
+			},
+		})
+	}
+
+	for _, server := range state.servers {
+		if server.deleted || server.vcenterId == "" || inVCenter[server.vcenterId] {
+			continue
+		}
+
+		serverID := server.id
+		drifts = append(drifts, Drift{
+			Class:  driftMissingVM,
+			Source: "database",
+			Object: server.name,
+			Detail: fmt.Sprintf("server %d has VM %s which is not in vCenter", server.id, server.vcenterId),
+			repair: func() error {
+				_, err := db.Exec("UPDATE virtual_machines SET deleted_at = NOW() WHERE id = ? AND deleted_at IS NULL", serverID)
+				return err
+			},
+		})
+	}
+
+	return drifts, nil
+}
+
+func findIpDrift(db *sql.DB, state *reconcileState) ([]Drift, error) {
+	var drifts []Drift
+
+	rows, err := db.Query(`SELECT ip FROM ip_adresses WHERE virtual_machine_id = 'claimed'
+		AND (claimed_at IS NULL OR claimed_at < NOW() - INTERVAL ? MINUTE)`, getIntEnvVar("IP_LEASE_MINUTES", 60))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var ip string
+		err = rows.Scan(&ip)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if state.jobIps[ip] {
+			continue
+		}
+
+		drifts = append(drifts, Drift{
+			Class:  driftStaleIpClaim,
+			Source: "ip_pool",
+			Object: ip,
+			Detail: "claimed while no server is being made with it",
+			repair: func() error {
+				return unclaimIp(ip)
+			},
+		})
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT ip, virtual_machine_id FROM ip_adresses WHERE virtual_machine_id NOT IN ('claimed', ?)", quarantinedIp)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ip, vmID string
+		err = rows.Scan(&ip, &vmID)
+		if err != nil {
+			return nil, err
+		}
+		if state.vcenterIDs[vmID] {
+			continue
+		}
+
+		drifts = append(drifts, Drift{
+			Class:  driftOrphanedIp,
+			Source: "ip_pool",
+			Object: ip,
+			Detail: "assigned to VM " + vmID + " which has no server in the database",
+			repair: func() error {
+				_, err := db.Exec("UPDATE ip_adresses SET virtual_machine_id = NULL, claimed_at = NULL WHERE ip = ? AND virtual_machine_id = ?", ip, vmID)
+				return err
+			},
+		})
+	}
+
+	return drifts, nil
+}
+
+func findSophosDrift(db *sql.DB, state *reconcileState) ([]Drift, error) {
+	names, err := getSophosIpHostNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+	for _, name := range names {
+		prefix := "OICT-AUTO-HOST-"
+		if strings.HasPrefix(name, "OICT-AUTO-HOST6-") {
+			prefix = "OICT-AUTO-HOST6-"
+		} else if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if state.sophosHosts[name] || sophosHostBelongsToUnknownOwner(name, state.unknownOwnerNames) {
+			continue
+		}
+
+		// the names are joined with a dash, so cutting at the first one gives back the same name
+		studentID, serverName, _ := strings.Cut(strings.TrimPrefix(name, prefix), "-")
+		isIPv6Host := prefix == "OICT-AUTO-HOST6-"
+		// an IPv6 host next to an IPv4 host that is still in use shares its rules, so those are left alone
+		removeRules := !isIPv6Host || !state.sophosHosts["OICT-AUTO-HOST-"+studentID+"-"+serverName]
+
+		drifts = append(drifts, Drift{
+			Class:  driftOrphanedSophosHost,
+			Source: "sophos",
+			Object: name,
+			Detail: "IP host has no server in the database",
+			repair: func() error {
+				if removeRules {
+					// the rules might already be gone, the host can only be removed when they are
+					err := removeFirewallRulesOfHostInSophos(studentID, serverName)
+					if err != nil {
+						log.Println("Error removing firewall rules of orphaned host: ", err)
+					}
+				}
+
+				if isIPv6Host {
+					return removeIPv6HostInSophos(studentID, serverName)
+				}
+				return removeIPHostInSophos(studentID, serverName)
+			},
+		})
+	}
+
+	return drifts, nil
+}
+
+func removeFirewallRulesOfHostInSophos(studentID, serverName string) error {
+	errInbound := removeInBoundRuleInSophos(studentID, serverName)
+	errOutbound := removeOutBoundRuleInSophos(studentID, serverName)
+	if errInbound != nil {
+		return errInbound
+	}
+
+	return errOutbound
+}
+
+func sophosHostBelongsToUnknownOwner(name string, unknownOwnerNames []string) bool {
+	for _, serverName := range unknownOwnerNames {
+		if strings.HasSuffix(name, "-"+serverName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findDNSDrift(db *sql.DB, state *reconcileState) ([]Drift, error) {
+	zones, err := listDNSZones()
+	if err != nil {
+		return nil, err
+	}
+
+	// the key of a record is the same for Technitium and the database
+	type zoneRecord struct {
+		zone, subdomain string
+		technitiumRecord
+	}
+
+	inDNS := make(map[string]bool)
+	var dnsRecords []zoneRecord
+	for _, zone := range zones {
+		records, err := getZoneRecordsInTechnitium(zone)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			subdomain, ok := platformDNSSubdomain(record.Name, zone)
+			if !ok || record.Value == "" {
+				continue
+			}
+			inDNS[dnsRecordKey(zone, subdomain, record.Type, record.Value)] = true
+			dnsRecords = append(dnsRecords, zoneRecord{zone, subdomain, record})
+		}
+	}
+
+	rows, err := db.Query(`SELECT s.virtual_machines_id, s.parent_domain, s.subDomain, s.record_type, s.record_value, v.id IS NOT NULL
+		FROM sub_domains s LEFT JOIN virtual_machines v ON v.id = s.virtual_machines_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drifts []Drift
+	inDB := make(map[string]bool)
+	for rows.Next() {
+		var (
+			serverID                                   int
+			parent, subdomain, recordType, recordValue string
+			serverExists                               bool
+		)
+		err = rows.Scan(&serverID, &parent, &subdomain, &recordType, &recordValue, &serverExists)
+		if err != nil {
+			return nil, err
+		}
+
+		key := dnsRecordKey(parent, subdomain, recordType, recordValue)
+		inDB[key] = true
+
+		switch {
+		case !serverExists:
+			drifts = append(drifts, Drift{
+				Class:  driftOrphanedDNSRecord,
+				Source: "dns",
+				Object: subdomain + "." + parent,
+				Detail: fmt.Sprintf("%s record %s of server %d which no longer exists", recordType, recordValue, serverID),
+				repair: func() error {
+					_, err := deleteRecordInDNS(parent, subdomain, recordType, recordValue)
+					if err != nil {
+						return err
+					}
+					return deleteRecordInDB(parent, subdomain, recordType, recordValue)
+				},
+			})
+		case !inDNS[key]:
+			drifts = append(drifts, Drift{
+				Class:  driftMissingDNSRecord,
+				Source: "database",
+				Object: subdomain + "." + parent,
+				Detail: fmt.Sprintf("%s record %s of server %d is not in Technitium", recordType, recordValue, serverID),
+				repair: func() error {
+					return createRecordInDNS(parent, subdomain, "3600", recordType, recordValue)
+				},
+			})
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range dnsRecords {
+		zone, subdomain := record.zone, record.subdomain
+		if inDB[dnsRecordKey(zone, subdomain, record.Type, record.Value)] {
+			continue
+		}
+
+		recordType, recordValue := record.Type, record.Value
+		drifts = append(drifts, Drift{
+			Class:  driftUnknownDNSRecord,
+			Source: "dns",
+			Object: record.Name,
+			Detail: fmt.Sprintf("%s record %s is not in the database", recordType, recordValue),
+			repair: func() error {
+				_, err := deleteRecordInDNS(zone, subdomain, recordType, recordValue)
+				return err
+			},
+		})
+	}
+
+	return drifts, nil
+}
+
+// platformDNSSubdomain returns the subdomain of a record in the zone when it is one the platform could have made,
+// those are below DOMAIN_PREFIX or SUBDOMAIN_PREFIX so the records of the zone itself are left alone
+func platformDNSSubdomain(name, zone string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	if !strings.HasSuffix(name, "."+zone) {
+		return "", false
+	}
+	subdomain := strings.TrimSuffix(name, "."+zone)
+
+	for _, prefix := range []string{getEnvVar("DOMAIN_PREFIX"), getEnvVar("SUBDOMAIN_PREFIX")} {
+		prefix = strings.ToLower(strings.Trim(prefix, "."))
+		if prefix != "" && (subdomain == prefix || strings.HasSuffix(subdomain, "."+prefix)) {
+			return subdomain, true
+		}
+	}
+
+	return "", false
+}
+
+// dnsRecordKey is how a record is compared between the database and Technitium, addresses are compared as addresses
+// because Technitium doesn't always write an IPv6 address the same way
+func dnsRecordKey(zone, subdomain, recordType, value string) string {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		value = addr.String()
+	}
+
+	return strings.ToLower(strings.Join([]string{strings.TrimSuffix(subdomain, "."), strings.TrimSuffix(zone, "."), recordType, value}, " "))
+}
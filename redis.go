@@ -28,6 +28,29 @@ func getFromRedis(key string) string {
 	return val
 }
 
+// getAndDeleteFromRedis removes the key while reading it, so only one request ever gets the value
+func getAndDeleteFromRedis(key string) string {
+	db := connectToRedis()
+	val, err := db.GetDel(context.Background(), key).Result()
+	if err != nil && err != redis.Nil {
+		log.Println("Error getting value from Redis: ", err, "Key: ", key)
+	}
+	return val
+}
+
+// setToRedisIfNotExists only sets the key when it isn't there yet, it returns false when the key already existed
+func setToRedisIfNotExists(key string, value string, expiration time.Duration) (bool, error) {
+	db := connectToRedis()
+	return db.SetNX(context.Background(), key, value, expiration).Result()
+}
+
+// getFromRedisWithError is getFromRedis for the callers that have to know if Redis could be reached,
+// a key that doesn't exist returns redis.Nil
+func getFromRedisWithError(key string) (string, error) {
+	db := connectToRedis()
+	return db.Get(context.Background(), key).Result()
+}
+
 func existsInRedis(key string) bool {
 	db := connectToRedis()
 	val, err := db.Exists(context.Background(), key).Result()
@@ -20,14 +20,34 @@ func main() {
 	s.GET("", GetServers)
 	s.POST("", CreateServer)
 
-	// s.PATCH("/:id", UpdateServer)
+	s.PATCH("/:id", UpdateServer)
+	s.POST("/:id/rebuild", RebuildServer)
+	s.POST("/:id/console", CreateServerConsole)
+	// the first password of the server, it can only be seen once by the owner
+	s.POST("/:id/initial-password", ViewInitialPassword)
+
+	s.GET("/:id/disks", GetServerDisks)
+	s.POST("/:id/disks", AddServerDisk)
+	s.DELETE("/:id/disks/:diskId", DeleteServerDisk)
+
+	s.GET("/:id/snapshots", GetServerSnapshots)
+	s.POST("/:id/snapshots", CreateServerSnapshot)
+	s.POST("/:id/snapshots/:snapshotId/revert", RevertServerSnapshot)
+	s.DELETE("/:id/snapshots/:snapshotId", DeleteServerSnapshot)
+
+	s.GET("/jobs/:id", GetProvisioningJob)
+	s.POST("/jobs/:id/ticket", CreateProvisioningJobTicket)
 
 	s.POST("/power/:id/:status", PowerServer)
 
+	s.GET("/:id/details", GetServerDetails)
 	s.GET("/:id", GetServers)
 
 	s.DELETE("/:id", DeleteServer)
 
+	// an EventSource can't send the bearer token, so the events use the ticket of /servers/jobs/:id/ticket
+	e.GET("/servers/jobs/:id/events", StreamProvisioningJob)
+
 	d := e.Group("/dns")
 	d.Use(checkIfLoggedIn)
 
@@ -60,15 +80,61 @@ func main() {
 	*/
 	e.GET("/templates", GetTemplates)
 
+	e.GET("/networks", GetNetworks, checkIfLoggedIn)
+	e.GET("/profiles", GetHardwareProfiles, checkIfLoggedIn)
+
+	m := e.Group("/me")
+	m.Use(checkIfLoggedIn)
+
+	m.GET("/quota", GetMyQuota)
+
+	// the SSH keys are put in authorized_keys of every new server of the user, password login is then turned off
+	m.GET("/ssh-keys", GetMySshKeys)
+	m.POST("/ssh-keys", CreateMySshKey)
+	m.DELETE("/ssh-keys/:id", DeleteMySshKey)
+
 	g := e.Group("/admin")
 	g.Use(checkIfLoggedInAsAdmin)
 
 	g.POST("/ipAddresses", CreateIpAdress)
+	g.POST("/ipAddresses/:ip/release", ReleaseIpAdress)
+	g.POST("/ipAddresses/:ip/quarantine", QuarantineIpAdress)
+
+	g.GET("/ipPools", GetIpPools)
+	// a pool is a cidr or a min and max address of at most 65536 addresses, so IPv6 pools have to be a
+	// min and max range inside the /64 of the network. The IPv6 address of a server is only set on its NIC by
+	// a guest customization (gateway_ipv6 of the network), start scripts get it as an argument and have to set it themselves
+	g.POST("/ipPools", CreateIpPool)
+	g.GET("/ipPools/:id/addresses", GetIpPoolAddresses)
+
+	g.POST("/networks", CreateNetwork)
+
+	g.POST("/profiles", CreateHardwareProfile)
+	g.DELETE("/profiles/:id", DeleteHardwareProfile)
+
+	// quotas of users (by SID) and LDAP groups (by CN), POST replaces the quota when it already exists
+	g.GET("/quotas", GetQuotas)
+	g.POST("/quotas", SetQuota)
+	g.DELETE("/quotas/:id", DeleteQuota)
 
 	// force the templates to be re-cached
 	g.GET("/templates/refresh", RefreshTemplates)
 	g.GET("/dataStores/refresh", RefreshDataStores)
 
+	g.POST("/servers/:id/restore", RestoreServer)
+	// copies a prepared server to every given student, each copy gets its own IP, firewall rules and owner
+	g.POST("/servers/:id/clone", CloneServer)
+	// makes the same server for every member of an LDAP group or every student in a CSV
+	g.POST("/servers/bulk", CreateServersInBulk)
+	g.POST("/servers/:id/transfer", TransferServer)
+
+	// shows what the next run of the expiry task would do without doing it
+	g.GET("/expiry/dry-run", GetExpiryDryRun)
+
+	// compares the database with vCenter, the IP pool, Sophos and the DNS, POST also repairs the given drift classes
+	g.GET("/reconcile", GetReconcileReport)
+	g.POST("/reconcile", RepairDrift)
+
 	a := e.Group("/auth")
 
 	a.POST("/login", Login)
@@ -92,5 +158,16 @@ func main() {
 	tickets.PATCH("/:id", UpdateTicket)
 	tickets.DELETE("/:id", DeleteTicket)
 
+	// finish or clean up the servers that were still being made when the API was stopped
+	go resumeProvisioningJobs()
+
+	startScheduler([]scheduledTask{
+		{Name: "enforceServerExpiry", Interval: schedulerInterval(), Run: enforceServerExpiry},
+		{Name: "purgeDeletedServers", Interval: schedulerInterval(), Run: purgeDeletedServers},
+		{Name: "expireStaleIpClaims", Interval: schedulerInterval(), Run: expireStaleIpClaims},
+		{Name: "reconcileServers", Interval: reconcileInterval(), Run: reconcileServers},
+		{Name: "cleanupOldSnapshots", Interval: schedulerInterval(), Run: cleanupOldSnapshots},
+	})
+
 	e.Start(":" + getEnvVar("APP_PORT"))
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+type scheduledTask struct {
+	Name     string
+	Interval time.Duration
+	Run      func()
+}
+
+// startScheduler runs every task once right away and then again every time its interval has passed
+func startScheduler(tasks []scheduledTask) {
+	for _, task := range tasks {
+		go func(task scheduledTask) {
+			ticker := time.NewTicker(task.Interval)
+			defer ticker.Stop()
+
+			for {
+				log.Println("Running scheduled task: ", task.Name)
+				runScheduledTask(task)
+				<-ticker.C
+			}
+		}(task)
+	}
+}
+
+// runScheduledTask makes sure a panicking task doesn't take the whole API down with it
+func runScheduledTask(task scheduledTask) {
+	defer timeTrack(time.Now(), task.Name)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Scheduled task ", task.Name, " panicked: ", r)
+		}
+	}()
+
+	task.Run()
+}
+
+func schedulerInterval() time.Duration {
+	return time.Duration(getIntEnvVar("SCHEDULER_INTERVAL_MINUTES", 60)) * time.Minute
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+func CreateServerConsole(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil || vCenterID == "" {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	ticket, err := acquirevCenterConsoleTicket(c.Request().Context(), vCenterID)
+	if errors.Is(err, errVMNotPoweredOn) {
+		return c.JSON(http.StatusConflict, "The server has to be powered on to open the console")
+	}
+	if err != nil {
+		log.Println("Error acquiring console ticket: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error opening console")
+	}
+
+	// the ticket is only valid for one connection, so the frontend has to request a new one every time
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"url":    ticket.Url,
+		"host":   ticket.Host,
+		"port":   ticket.Port,
+		"ticket": ticket.Ticket,
+	})
+}
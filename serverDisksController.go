@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+type DataDisk struct {
+	ID            int64  `json:"id"`
+	VcenterDiskId string `json:"vcenter_disk_id"`
+	// in GB
+	Capacity  int    `json:"capacity"`
+	CreatedAt string `json:"created_at"`
+}
+
+type dataDiskJsonBody struct {
+	Capacity int `json:"capacity"`
+}
+
+func GetServerDisks(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	_, _, err = getServerForUser(c, db)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	disks, err := getServerDisks(db, c.Param("id"))
+	if err != nil {
+		log.Println("Error fetching data disks: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error fetching data disks")
+	}
+
+	return c.JSON(http.StatusOK, disks)
+}
+
+func AddServerDisk(c echo.Context) error {
+	var jsonBody dataDiskJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	if jsonBody.Capacity < 1 {
+		return c.JSON(http.StatusBadRequest, "A data disk needs at least 1 GB")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, ownerId, err := getServerForUser(c, db)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	if vCenterID == "" {
+		return c.JSON(http.StatusConflict, "The server is still being made, try again later")
+	}
+
+	disks, err := getServerDisks(db, c.Param("id"))
+	if err != nil {
+		log.Println("Error fetching data disks: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error fetching data disks")
+	}
+
+	if len(disks) >= getIntEnvVar("MAX_DATA_DISKS", 4) {
+		return c.JSON(http.StatusBadRequest, "This server already has the maximum amount of data disks")
+	}
+
+	_, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+	if !isAdmin {
+		// held until the disk is saved in server_disks
+		quotaTx, err := lockQuota(db, ownerId)
+		if err != nil {
+			log.Println("Error locking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+		}
+		defer quotaTx.Rollback()
+
+		quotaMessage, err := checkQuota(quotaTx, ownerId, Quota{Storage: jsonBody.Capacity})
+		if err != nil {
+			log.Println("Error checking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+		}
+		if quotaMessage != "" {
+			return c.JSON(http.StatusBadRequest, quotaMessage)
+		}
+	}
+
+	ctx := c.Request().Context()
+	diskID, err := createvCenterVMDisk(ctx, vCenterID, jsonBody.Capacity)
+	if err != nil {
+		log.Println("Error adding disk in vCenter: ", err)
+		return c.JSON(http.StatusBadRequest, "Error adding data disk")
+	}
+
+	result, err := db.Exec("INSERT INTO server_disks (virtual_machines_id, vcenter_disk_id, capacity) VALUES (?, ?, ?)", c.Param("id"), diskID, jsonBody.Capacity)
+	if err != nil {
+		log.Println("Error saving data disk: ", err)
+		// don't leave a disk behind that isn't counted against the quota
+		err = deletevCenterVMDisk(ctx, vCenterID, diskID)
+		if err != nil {
+			log.Println("Error removing disk in vCenter: ", err)
+		}
+		return c.JSON(http.StatusInternalServerError, "Error adding data disk")
+	}
+
+	id, _ := result.LastInsertId()
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{"message": "Data disk added!", "id": id, "vcenter_disk_id": diskID})
+}
+
+func DeleteServerDisk(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	var vCenterDiskID string
+	err = db.QueryRow("SELECT vcenter_disk_id FROM server_disks WHERE id = ? AND virtual_machines_id = ?", c.Param("diskId"), c.Param("id")).Scan(&vCenterDiskID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "Can't find data disk with that ID")
+	}
+
+	err = deletevCenterVMDisk(c.Request().Context(), vCenterID, vCenterDiskID)
+	if err != nil {
+		log.Println("Error removing disk in vCenter: ", err)
+		return c.JSON(http.StatusBadRequest, "Error removing data disk, try again with the server powered off")
+	}
+
+	_, err = db.Exec("DELETE FROM server_disks WHERE id = ?", c.Param("diskId"))
+	if err != nil {
+		log.Println("Error deleting data disk: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error removing data disk from database")
+	}
+
+	return c.JSON(http.StatusOK, "Data disk removed!")
+}
+
+func getServerDisks(db *sql.DB, serverID string) ([]DataDisk, error) {
+	rows, err := db.Query("SELECT id, vcenter_disk_id, capacity, created_at FROM server_disks WHERE virtual_machines_id = ? ORDER BY id", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disks := []DataDisk{}
+	for rows.Next() {
+		var disk DataDisk
+		err = rows.Scan(&disk.ID, &disk.VcenterDiskId, &disk.Capacity, &disk.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		disks = append(disks, disk)
+	}
+
+	return disks, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the amount of days before the end date a warning is sent to the owner of the server
+var expiryWarningDays = []int{14, 7, 1}
+
+type expiryAction struct {
+	ServerID   int    `json:"server_id"`
+	ServerName string `json:"server_name"`
+	UsersId    string `json:"users_id"`
+	VcenterId  string `json:"vcenter_id"`
+	EndDate    string `json:"end_date"`
+	// warn_14, warn_7, warn_1, power_off or teardown
+	Action string `json:"action"`
+}
+
+func GetExpiryDryRun(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	actions, err := planExpiryActions(db, time.Now())
+	if err != nil {
+		log.Println("Error planning expiry actions: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error planning expiry actions")
+	}
+
+	return c.JSON(http.StatusOK, actions)
+}
+
+// enforceServerExpiry is run by the scheduler and warns, powers off and removes the servers past their end date
+func enforceServerExpiry() {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return
+	}
+	defer db.Close()
+
+	actions, err := planExpiryActions(db, time.Now())
+	if err != nil {
+		log.Println("Error planning expiry actions: ", err)
+		return
+	}
+
+	for _, action := range actions {
+		err = executeExpiryAction(action, db)
+		if err != nil {
+			logErrorInDB(fmt.Errorf("expiry action %s failed for server %d: %v", action.Action, action.ServerID, err))
+			log.Println("Error executing expiry action: ", err)
+		}
+	}
+}
+
+// planExpiryActions works out what should happen to every server, without doing anything yet
+func planExpiryActions(db *sql.DB, now time.Time) ([]expiryAction, error) {
+	gracePeriod := getIntEnvVar("EXPIRY_GRACE_DAYS", 14)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	rows, err := db.Query("SELECT id, users_id, vcenter_id, name, end_date FROM virtual_machines WHERE vcenter_id != '' AND deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []expiryAction{}
+	for rows.Next() {
+		var action expiryAction
+		err = rows.Scan(&action.ServerID, &action.UsersId, &action.VcenterId, &action.ServerName, &action.EndDate)
+		if err != nil {
+			return nil, err
+		}
+
+		endDate, err := time.Parse("2006-01-02", action.EndDate)
+		if err != nil {
+			log.Println("Invalid end date for server ", action.ServerID, ": ", err)
+			continue
+		}
+
+		action.Action = expiryActionFor(endDate, today, gracePeriod)
+		if action.Action == "" || expiryNoticeWasSent(db, action) {
+			continue
+		}
+
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// expiryActionFor returns what should happen to a server with the end date today, or "" when nothing has to happen yet
+func expiryActionFor(endDate, today time.Time, gracePeriod int) string {
+	daysLeft := int(endDate.Sub(today).Hours() / 24)
+
+	switch {
+	case daysLeft <= -gracePeriod:
+		return "teardown"
+	case daysLeft <= 0:
+		return "power_off"
+	}
+
+	// only the closest warning is sent, so a server that was made 3 days before its end date gets one warning
+	action := ""
+	for _, days := range expiryWarningDays {
+		if daysLeft <= days {
+			action = "warn_" + strconv.Itoa(days)
+		}
+	}
+
+	return action
+}
+
+// executeExpiryAction doesn't need LDAP to power off or tear down the server, most expired servers belong to students
+// that are already removed from it, those just don't get a notification
+func executeExpiryAction(action expiryAction, db *sql.DB) error {
+	var title, body string
+	var err error
+
+	switch action.Action {
+	case "teardown":
+		studentID, err := getServerStudentID(db, action.ServerID, action.UsersId)
+		if err != nil {
+			return err
+		}
+
+		err = teardownServer(action.ServerID, action.VcenterId, action.ServerName, studentID, db)
+		if err != nil {
+			return err
+		}
+
+		title = "Server is verwijderd"
+		body = "Je server(" + action.ServerName + ") is verwijderd omdat de einddatum (" + action.EndDate + ") verlopen is."
+	case "power_off":
+		err = forcePowerOff(context.Background(), action.VcenterId)
+		if err != nil {
+			return err
+		}
+
+		title = "Server is uitgezet"
+		body = "Je server(" + action.ServerName + ") is uitgezet omdat de einddatum (" + action.EndDate + ") verlopen is. Over " +
+			strconv.Itoa(getIntEnvVar("EXPIRY_GRACE_DAYS", 14)) + " dagen wordt de server verwijderd, verleng de einddatum als je de server nog nodig hebt."
+	default:
+		title = "Server verloopt binnenkort"
+		body = "Je server(" + action.ServerName + ") verloopt op " + action.EndDate + ". Verleng de einddatum als je de server nog nodig hebt."
+	}
+
+	if action.Action != "teardown" {
+		_, err = db.Exec("INSERT INTO expiry_notices (virtual_machines_id, end_date, notice) VALUES (?, ?, ?)", action.ServerID, action.EndDate, action.Action)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Println("Expiry action ", action.Action, " done for server ", action.ServerID, " of ", action.UsersId)
+
+	_, _, _, email, err := fetchUserInfoWithSID(action.UsersId)
+	if err != nil {
+		log.Println("Error fetching owner of server, no notification is sent: ", err)
+		return nil
+	}
+
+	createNotificationForUser(db, action.UsersId, title, body)
+	if email != "" {
+		sendEmailNotification(email, title, body)
+	}
+
+	return nil
+}
+
+// expiryNoticeWasSent checks if the action was already done for the current end date of the server,
+// so extending the end date makes the warnings get sent again
+func expiryNoticeWasSent(db *sql.DB, action expiryAction) bool {
+	var sent bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM expiry_notices WHERE virtual_machines_id = ? AND end_date = ? AND notice = ?)",
+		action.ServerID, action.EndDate, action.Action).Scan(&sent)
+	if err != nil {
+		log.Println("Error checking expiry notices: ", err)
+		return true
+	}
+
+	return sent
+}
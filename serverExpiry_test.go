@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryActionFor(t *testing.T) {
+	today := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		endDate string
+		want    string
+	}{
+		{"far away", "2024-08-01", ""},
+		{"just outside the first warning", "2024-06-30", ""},
+		{"first warning", "2024-06-29", "warn_14"},
+		{"between the first and second warning", "2024-06-25", "warn_14"},
+		{"past the second warning gets the closest one", "2024-06-20", "warn_7"},
+		{"second warning", "2024-06-22", "warn_7"},
+		{"last warning", "2024-06-16", "warn_1"},
+		{"end date is today", "2024-06-15", "power_off"},
+		{"inside the grace period", "2024-06-02", "power_off"},
+		{"grace period is over", "2024-06-01", "teardown"},
+		{"long expired", "2023-01-01", "teardown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endDate, err := time.Parse("2006-01-02", test.endDate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := expiryActionFor(endDate, today, 14); got != test.want {
+				t.Errorf("expiryActionFor(%s) = %q, want %q", test.endDate, got, test.want)
+			}
+		})
+	}
+}
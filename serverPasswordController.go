@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// no characters that look alike or that a shell would treat differently, the password is passed to the start script
+const initialPasswordChars = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const initialPasswordLength = 16
+
+func initialPasswordKey(serverID int64) string {
+	return "initial_password:" + strconv.FormatInt(serverID, 10)
+}
+
+// getInitialPassword returns the first password of the server, it is made the first time a step of the provisioning
+// job asks for it so every step of the job gets the same one. It is only kept in Redis until the owner has seen it
+// or INITIAL_PASSWORD_TTL_HOURS have passed, the owner has to change it on the first login
+func getInitialPassword(serverID int64) (string, error) {
+	password := make([]byte, initialPasswordLength)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(initialPasswordChars))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = initialPasswordChars[n.Int64()]
+	}
+
+	// SET NX so a password that an earlier step already set up in the guest is never replaced
+	ttl := time.Duration(getIntEnvVar("INITIAL_PASSWORD_TTL_HOURS", 168)) * time.Hour
+	created, err := setToRedisIfNotExists(initialPasswordKey(serverID), string(password), ttl)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		return string(password), nil
+	}
+
+	// the step fails when the password can't be read, otherwise the guest would get a password nobody can see
+	existing, err := getFromRedisWithError(initialPasswordKey(serverID))
+	if err != nil {
+		return "", err
+	}
+	if existing == "" {
+		return "", fmt.Errorf("initial password of server %d is empty", serverID)
+	}
+
+	return existing, nil
+}
+
+// forgetInitialPassword makes sure a new provisioning job of the server gets a new password
+func forgetInitialPassword(serverID int64) {
+	deleteFromRedis(initialPasswordKey(serverID))
+}
+
+// ViewInitialPassword shows the first password of the server to its owner once, it is never put in a notification
+// or email. Admins get the same answer as everybody else that doesn't own the server, so they can't use it up
+func ViewInitialPassword(c echo.Context) error {
+	userId, _, _, _ := getUserAssociatedWithJWT(c)
+
+	serverID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid server ID")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	if !checkIfServerBelongsToUser(c.Param("id"), userId, db) {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	// the start script hasn't set the password yet, showing it now would make the job make a new one
+	activeJob, err := serverHasActiveJob(db, serverID)
+	if err != nil {
+		log.Println("Error checking provisioning jobs: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	if activeJob {
+		return c.JSON(http.StatusConflict, "The server is still being made, try again when it is done")
+	}
+
+	password := getAndDeleteFromRedis(initialPasswordKey(serverID))
+	if password == "" {
+		return c.JSON(http.StatusGone, "The password of this server was already shown or has expired")
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"password": password,
+	})
+}
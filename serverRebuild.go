@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+type serverRebuildJsonBody struct {
+	// the template to reinstall the server with, empty keeps the current one
+	OperatingSystem string `json:"operating_system"`
+	// the data disks are removed together with the old VM, so the user has to say that is fine
+	DiscardDisks bool `json:"discard_disks"`
+}
+
+func RebuildServer(c echo.Context) error {
+	var jsonBody serverRebuildJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, ownerId, err := getServerForUser(c, db)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	serverID := stringToInt64(c.Param("id"))
+
+	busy, err := serverHasActiveJob(db, serverID)
+	if err != nil {
+		log.Println("Error checking provisioning jobs: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	if busy || vCenterID == "" {
+		return c.JSON(http.StatusConflict, "The server is still being made or rebuilt, try again later")
+	}
+
+	var disks int
+	err = db.QueryRow("SELECT COUNT(*) FROM server_disks WHERE virtual_machines_id = ?", serverID).Scan(&disks)
+	if err != nil {
+		log.Println("Error counting data disks: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	if disks > 0 && !jsonBody.DiscardDisks {
+		return c.JSON(http.StatusConflict, "The data disks of the server and everything on them will be removed, send discard_disks to rebuild anyway")
+	}
+
+	request := serverCreationJsonBody{}
+	var ip, ipv6 string
+	err = db.QueryRow("SELECT name, description, operating_system, cpu, storage, memory, ip, ipv6 FROM virtual_machines WHERE id = ?", serverID).
+		Scan(&request.Name, &request.Description, &request.OperatingSystem, &request.Cpu, &request.Storage, &request.Memory, &ip, &ipv6)
+	if err != nil {
+		log.Println("Error fetching server: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	if jsonBody.OperatingSystem != "" {
+		templates := getTemplatesFromVCenter(c.Request().Context())
+		if !checkIfItemIsKeyOfArray(jsonBody.OperatingSystem, templates) {
+			return c.JSON(http.StatusBadRequest, "Invalid operating system")
+		}
+		request.OperatingSystem = jsonBody.OperatingSystem
+	}
+
+	network := getServerNetwork(db, serverID)
+	request.Network = &network
+
+	// the Sophos objects and the VM are named after the owner, who isn't always the one rebuilding
+	fullName, studentID, _, _, err := fetchUserInfoWithSID(ownerId)
+	if err != nil {
+		log.Println("Error fetching owner of server: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	jobID, err := createProvisioningJob(db, &provisioningJob{
+		Kind:      "rebuild",
+		UsersId:   ownerId,
+		StudentID: studentID,
+		FullName:  fullName,
+		Request:   request,
+		IP:        ip,
+		IPv6:      ipv6,
+		ServerId:  serverID,
+	})
+	if err != nil {
+		log.Println("Error creating provisioning job: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error rebuilding server")
+	}
+
+	// the new VM gets a new password, the one of the old VM can't be used anymore
+	forgetInitialPassword(serverID)
+
+	go runProvisioningJob(jobID)
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{"message": "Server is being rebuilt!", "job_id": jobID})
+}
+
+// serverHasActiveJob is true while the server is being made or rebuilt, other changes to the server have to wait until then
+func serverHasActiveJob(db *sql.DB, serverID int64) (bool, error) {
+	var busy bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM provisioning_jobs WHERE server_id = ? AND status IN ('pending', 'running'))", serverID).Scan(&busy)
+
+	return busy, err
+}
+
+// getServerNetwork returns the network the server was made on, which is only stored in the request of its provisioning job
+func getServerNetwork(db *sql.DB, serverID int64) string {
+	var request string
+	err := db.QueryRow("SELECT request FROM provisioning_jobs WHERE server_id = ? ORDER BY id DESC LIMIT 1", serverID).Scan(&request)
+	if err != nil {
+		// servers made before provisioning jobs keep the network of the template
+		return ""
+	}
+
+	var jsonBody serverCreationJsonBody
+	err = json.Unmarshal([]byte(request), &jsonBody)
+	if err != nil || jsonBody.Network == nil {
+		return ""
+	}
+
+	return *jsonBody.Network
+}
+
+// rebuildSwapStep gives the server and its IPs to the new VM and then removes the old VM, it can be run again when
+// it was interrupted. The new VM is only powered on in the next step, so the two VMs never use the same IP at once
+func rebuildSwapStep(job *provisioningJob, db *sql.DB) error {
+	ctx := context.Background()
+
+	var oldVCenterID string
+	err := db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ?", job.ServerId).Scan(&oldVCenterID)
+	if err != nil {
+		return err
+	}
+
+	// the swap is saved before the old VM is removed, so a failed rebuild never deletes the VM the server points at
+	if oldVCenterID != job.VcenterId {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec("UPDATE virtual_machines SET vcenter_id = ?, operating_system = ?, updated_at = NOW() WHERE id = ?", job.VcenterId, job.Request.OperatingSystem, job.ServerId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE ip_adresses SET virtual_machine_id = ? WHERE virtual_machine_id = ?", job.VcenterId, oldVCenterID)
+		if err != nil {
+			return err
+		}
+
+		// the data disks are removed together with the old VM, RebuildServer made sure the user is fine with that
+		_, err = tx.Exec("DELETE FROM server_disks WHERE virtual_machines_id = ?", job.ServerId)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the old VM still has the name of the server, when the step is run again that is the only way to find it
+	serverVMName := "OICT-AUTO-" + job.StudentID + "-" + job.ServerName
+	oldVCenterID, err = getvCenterVMIDByName(ctx, serverVMName)
+	if err != nil {
+		return err
+	}
+	if oldVCenterID != "" && oldVCenterID != job.VcenterId {
+		err = deletevCenterVM(ctx, oldVCenterID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the next rebuild needs the temporary name to be free again
+	return renamevCenterVM(ctx, job.VcenterId, serverVMName)
+}
+
+// failRebuildJob removes the new VM when the server still uses the old one, after the swap the server keeps the new VM
+func failRebuildJob(job *provisioningJob, db *sql.DB) {
+	logErrorInDB(fmt.Errorf("Server rebuild failed for server: " + job.ServerName))
+
+	var currentVCenterID string
+	err := db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ?", job.ServerId).Scan(&currentVCenterID)
+	if err != nil {
+		log.Println("Error fetching server: ", err)
+	}
+
+	// without the old VM the new one is all the server has left, so it is kept
+	ctx := context.Background()
+	if job.VcenterId != "" && err == nil && currentVCenterID != job.VcenterId && vCenterVMExists(ctx, currentVCenterID) {
+		err = deletevCenterVM(ctx, job.VcenterId)
+		if err != nil {
+			log.Println("Error deleting new VM of failed rebuild: ", err)
+		}
+	}
+
+	title := "Server opnieuw installeren mislukt"
+	body := "Het opnieuw installeren van je server(" + job.ServerName + ") is mislukt, probeer het later opnieuw of maak een ticket aan."
+	notifyServerOwner(job.UsersId, title, body, db)
+}
+
+func notifyRebuildJobDone(job *provisioningJob, db *sql.DB) {
+	title := "Server is opnieuw geïnstalleerd"
+	body := "Je server(" + job.ServerName + ") is opnieuw geïnstalleerd met " + job.Request.OperatingSystem + ", het ip, de DNS records en de firewall zijn hetzelfde gebleven. " +
+		serverLoginMessage(job, db)
+	notifyServerOwner(job.UsersId, title, body, db)
+}
+
+// notifyServerOwner sends the notification in the app and by email when the user has an email address
+func notifyServerOwner(userId, title, body string, db *sql.DB) {
+	createNotificationForUser(db, userId, title, body)
+
+	_, _, _, email, err := fetchUserInfoWithSID(userId)
+	if err != nil {
+		log.Println("Error fetching user info: ", err)
+		return
+	}
+	if email != "" {
+		sendEmailNotification(email, title, body)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"time"
+)
+
+type snapshotJsonBody struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func GetServerSnapshots(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil || vCenterID == "" {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	snapshots, err := listvCenterSnapshots(c.Request().Context(), vCenterID)
+	if err != nil {
+		log.Println("Error fetching snapshots: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error fetching snapshots")
+	}
+
+	return c.JSON(http.StatusOK, snapshots)
+}
+
+func CreateServerSnapshot(c echo.Context) error {
+	var jsonBody snapshotJsonBody
+	if err := c.Bind(&jsonBody); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
+
+	if jsonBody.Name == "" {
+		jsonBody.Name = time.Now().Format("2006-01-02 15:04")
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil || vCenterID == "" {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	ctx := c.Request().Context()
+
+	snapshots, err := listvCenterSnapshots(ctx, vCenterID)
+	if err != nil {
+		log.Println("Error fetching snapshots: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error fetching snapshots")
+	}
+
+	if len(snapshots) >= getIntEnvVar("MAX_SNAPSHOTS", 3) {
+		return c.JSON(http.StatusBadRequest, "This server already has the maximum amount of snapshots, delete one first")
+	}
+
+	err = createvCenterSnapshot(ctx, vCenterID, jsonBody.Name, jsonBody.Description)
+	if err != nil {
+		log.Println("Error creating snapshot: ", err)
+		return c.JSON(http.StatusBadRequest, "Error creating snapshot")
+	}
+
+	return c.JSON(http.StatusCreated, "Snapshot created!")
+}
+
+func RevertServerSnapshot(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil || vCenterID == "" {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	ctx := c.Request().Context()
+
+	_, err = findvCenterSnapshot(ctx, vCenterID, c.Param("snapshotId"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "Can't find snapshot with that ID")
+	}
+
+	powerState, err := getvCenterPowerState(ctx, vCenterID)
+	if err != nil {
+		log.Println("Error fetching power state: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error reverting to snapshot")
+	}
+
+	err = revertvCenterSnapshot(ctx, c.Param("snapshotId"))
+	if err != nil {
+		log.Println("Error reverting snapshot: ", err)
+		return c.JSON(http.StatusBadRequest, "Error reverting to snapshot")
+	}
+
+	// the snapshots are made without memory, so the VM is powered off after reverting,
+	// it is only started again when it was running before
+	if powerState != "POWERED_ON" {
+		return c.JSON(http.StatusOK, "Reverted to snapshot!")
+	}
+
+	err = powerOn(ctx, vCenterID)
+	if err != nil {
+		log.Println("Error powering on server: ", err)
+		return c.JSON(http.StatusOK, "Reverted to snapshot, but the server could not be powered on")
+	}
+
+	return c.JSON(http.StatusOK, "Reverted to snapshot!")
+}
+
+func DeleteServerSnapshot(c echo.Context) error {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	vCenterID, _, err := getServerForUser(c, db)
+	if err != nil || vCenterID == "" {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	ctx := c.Request().Context()
+
+	_, err = findvCenterSnapshot(ctx, vCenterID, c.Param("snapshotId"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "Can't find snapshot with that ID")
+	}
+
+	err = deletevCenterSnapshot(ctx, c.Param("snapshotId"))
+	if err != nil {
+		log.Println("Error deleting snapshot: ", err)
+		return c.JSON(http.StatusBadRequest, "Error deleting snapshot")
+	}
+
+	return c.JSON(http.StatusOK, "Snapshot deleted!")
+}
+
+// cleanupOldSnapshots is run by the scheduler and removes the snapshots that are older than SNAPSHOT_MAX_AGE_DAYS,
+// old snapshots slow the VM down and fill the datastore
+func cleanupOldSnapshots() {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT vcenter_id FROM virtual_machines WHERE vcenter_id != ''")
+	if err != nil {
+		log.Println("Error fetching servers: ", err)
+		return
+	}
+
+	var vCenterIDs []string
+	for rows.Next() {
+		var vCenterID string
+		err = rows.Scan(&vCenterID)
+		if err != nil {
+			log.Println("Error scanning server: ", err)
+			continue
+		}
+		vCenterIDs = append(vCenterIDs, vCenterID)
+	}
+	rows.Close()
+
+	maxAge := time.Duration(getIntEnvVar("SNAPSHOT_MAX_AGE_DAYS", 7)) * 24 * time.Hour
+	ctx := context.Background()
+
+	for _, vCenterID := range vCenterIDs {
+		snapshots, err := listvCenterSnapshots(ctx, vCenterID)
+		if err != nil {
+			log.Println("Error fetching snapshots of VM ", vCenterID, ": ", err)
+			continue
+		}
+
+		for _, snapshot := range snapshots {
+			if time.Since(snapshot.CreatedAt) < maxAge {
+				continue
+			}
+
+			err = deletevCenterSnapshot(ctx, snapshot.ID)
+			if err != nil {
+				log.Println("Error deleting old snapshot ", snapshot.ID, " of VM ", vCenterID, ": ", err)
+				continue
+			}
+			log.Println("Deleted old snapshot ", snapshot.Name, " of VM ", vCenterID)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,9 +26,11 @@ type DBServers struct {
 	Description     string
 	EndDate         string
 	OperatingSystem string
+	Cpu             int
 	Storage         int
 	Memory          int
 	IP              string
+	IPv6            string
 }
 
 type vCenterServers struct {
@@ -45,9 +49,11 @@ type PowerStatusReturn struct {
 	Description     string
 	EndDate         string
 	OperatingSystem string
+	Cpu             int
 	Storage         int
 	Memory          int
 	IP              string
+	IPv6            string
 	PowerStatus     string
 }
 
@@ -61,6 +67,19 @@ type serverCreationJsonBody struct {
 	HomeIPs         *[]string `json:"home_ips"`
 	SubDomain       *string   `json:"sub_domain"`
 	DomainZone      *string   `json:"domain_zone"`
+	Network         *string   `json:"network"`
+	Profile         *string   `json:"profile"`
+	// only for admins, the SID or sAMAccountName of the user the server is made for
+	Owner *string `json:"owner"`
+	// only set from the hardware profile, 0 keeps the CPU count of the template
+	Cpu int `json:"cpu"`
+}
+
+type serverUpdateJsonBody struct {
+	Description *string `json:"description"`
+	EndDate     *string `json:"end_date"`
+	Storage     *int    `json:"storage"`
+	Memory      *int    `json:"memory"`
 }
 
 type startScript struct {
@@ -68,13 +87,20 @@ type startScript struct {
 	Password         string `json:"password"`
 	ScriptLocation   string `json:"scriptLocation"`
 	ScriptExecutable string `json:"scriptExecutable"`
+	// the network servers made from this template are put on when the user doesn't pick one
+	Network string `json:"network"`
+	// when set vCenter configures the guest on its first boot, the start script is then only run
+	// when scriptExecutable is set as well
+	Customization *templateCustomization `json:"customization"`
 }
 
 func GetServers(c echo.Context) error {
 	id := c.Param("id")
 	UserId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
-	session := getVCenterSession()
-	serversFromVCenter := getPowerStatusFromvCenter(session, "")
+	serversFromVCenter, err := listvCenterVMs(c.Request().Context())
+	if err != nil {
+		log.Println("Error fetching VMs from vCenter: ", err)
+	}
 
 	db, err := connectToDB()
 	if err != nil {
@@ -104,15 +130,64 @@ func GetServers(c echo.Context) error {
 	return c.JSON(http.StatusOK, RowsArr)
 }
 
+type ServerDetails struct {
+	PowerStatusReturn
+	vCenterGuestDetails
+}
+
+func GetServerDetails(c echo.Context) error {
+	id := c.Param("id")
+	UserId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	rows, err := getServersFromSQL(db, id, UserId, isAdmin)
+	if err != nil {
+		log.Println("Error executing query: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return c.JSON(http.StatusNotFound, "No servers found for the given ID")
+	}
+
+	var details ServerDetails
+	s := &details.PowerStatusReturn
+	err = rows.Scan(&s.ID, &s.UsersId, &s.VcenterId, &s.Name, &s.Description, &s.EndDate, &s.OperatingSystem, &s.Cpu, &s.Storage, &s.Memory, &s.IP, &s.IPv6)
+	if err != nil {
+		log.Println("Error scanning row: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+
+	if s.VcenterId == "" {
+		return c.JSON(http.StatusConflict, "The server is still being made, try again later")
+	}
+
+	details.vCenterGuestDetails, err = getvCenterGuestDetails(c.Request().Context(), s.VcenterId)
+	if err != nil {
+		log.Println("Error fetching server details from vCenter: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error fetching server details")
+	}
+	s.PowerStatus = details.vCenterGuestDetails.PowerStatus
+
+	return c.JSON(http.StatusOK, details)
+}
+
 func getServersFromSQL(db *sql.DB, id string, user string, admin bool) (*sql.Rows, error) {
 	if id != "" && !admin {
-		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, storage, memory, ip FROM virtual_machines WHERE id = ? and users_id = ?", id, user)
+		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, cpu, storage, memory, ip, ipv6 FROM virtual_machines WHERE id = ? and users_id = ? AND deleted_at IS NULL", id, user)
 	} else if id != "" && admin {
-		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, storage, memory, ip FROM virtual_machines WHERE id = ?", id)
+		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, cpu, storage, memory, ip, ipv6 FROM virtual_machines WHERE id = ? AND deleted_at IS NULL", id)
 	} else if admin {
-		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, storage, memory, ip FROM virtual_machines")
+		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, cpu, storage, memory, ip, ipv6 FROM virtual_machines WHERE deleted_at IS NULL")
 	} else {
-		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, storage, memory, ip FROM virtual_machines WHERE users_id = ?", user)
+		return db.Query("SELECT id, users_id, vcenter_id, name, description, end_date, operating_system, cpu, storage, memory, ip, ipv6 FROM virtual_machines WHERE users_id = ? AND deleted_at IS NULL", user)
 	}
 }
 
@@ -123,7 +198,7 @@ func getPowerStatusRows(rows *sql.Rows, serversFromVCenter []vCenterServers) ([]
 
 	for rows.Next() {
 		var s PowerStatusReturn
-		err := rows.Scan(&s.ID, &s.UsersId, &s.VcenterId, &s.Name, &s.Description, &s.EndDate, &s.OperatingSystem, &s.Storage, &s.Memory, &s.IP)
+		err := rows.Scan(&s.ID, &s.UsersId, &s.VcenterId, &s.Name, &s.Description, &s.EndDate, &s.OperatingSystem, &s.Cpu, &s.Storage, &s.Memory, &s.IP, &s.IPv6)
 		if err != nil {
 			return nil, err
 		}
@@ -171,63 +246,223 @@ func DeleteServer(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, "Error converting ID to int")
 	}
 
-	// delete all the DNS records for the server
-	err = deleteDNSRecordsForServer(idInt, db)
-	if err != nil {
-		log.Println("Error deleting DNS records for server: ", err)
-		return c.JSON(http.StatusBadRequest, "Error deleting DNS records for server")
-	}
-
 	// get the vCenter ID from the database
-	var (
-		vCenterID  string
-		serverName string
-	)
+	var vCenterID string
 
-	userID, isAdmin, _, studentID := getUserAssociatedWithJWT(c)
+	userID, isAdmin, _, _ := getUserAssociatedWithJWT(c)
 
 	if isAdmin {
-		err = db.QueryRow("SELECT vcenter_id, name FROM virtual_machines WHERE id = ?", id).Scan(&vCenterID, &serverName)
+		err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ? AND deleted_at IS NULL", id).Scan(&vCenterID)
 	} else {
-		err = db.QueryRow("SELECT vcenter_id, name FROM virtual_machines WHERE id = ? and users_id = ?", id, userID).Scan(&vCenterID, &serverName)
+		err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ? and users_id = ? AND deleted_at IS NULL", id, userID).Scan(&vCenterID)
 	}
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
 	}
 
+	// the job would still make the VM, its firewall rules and DNS records, but the server it belongs to is already gone
+	busy, err := serverHasActiveJob(db, int64(idInt))
+	if err != nil {
+		log.Println("Error checking provisioning jobs: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	if busy {
+		return c.JSON(http.StatusConflict, "The server is still being made or rebuilt, try again later")
+	}
+
+	if vCenterID != "" {
+		err = forcePowerOff(c.Request().Context(), vCenterID)
+		if err != nil {
+			log.Println("Error powering off server: ", err)
+			return c.JSON(http.StatusBadRequest, "Error powering off server")
+		}
+	}
+
+	// the server is only marked as deleted, the IP, DNS records and firewall rules stay reserved
+	// until purgeDeletedServers removes the server after the retention period
+	_, err = db.Exec("UPDATE virtual_machines SET deleted_at = NOW() WHERE id = ?", idInt)
+	if err != nil {
+		log.Println("Error executing statement: ", err)
+		return c.JSON(http.StatusBadRequest, "Error deleting server from database")
+	}
+
+	return c.JSON(http.StatusCreated, "Server deleted!")
+}
+
+func RestoreServer(c echo.Context) error {
+	id := c.Param("id")
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
+
+	var vCenterID string
+	err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ? AND deleted_at IS NOT NULL", id).Scan(&vCenterID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find deleted server with that ID")
+	}
+
+	_, err = db.Exec("UPDATE virtual_machines SET deleted_at = NULL WHERE id = ?", id)
+	if err != nil {
+		log.Println("Error executing statement: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error restoring server in database")
+	}
+
+	if vCenterID != "" {
+		err = powerOn(c.Request().Context(), vCenterID)
+		if err != nil {
+			log.Println("Error powering on server: ", err)
+			return c.JSON(http.StatusBadRequest, "Server restored, but it could not be powered on")
+		}
+	}
+
+	return c.JSON(http.StatusOK, "Server restored!")
+}
+
+// purgeDeletedServers is run by the scheduler and removes the servers that were deleted longer ago than the retention period
+func purgeDeletedServers() {
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return
+	}
+	defer db.Close()
+
+	type deletedServer struct {
+		id                       int
+		usersId, vCenterID, name string
+	}
+
+	rows, err := db.Query("SELECT id, users_id, vcenter_id, name FROM virtual_machines WHERE deleted_at IS NOT NULL AND deleted_at < NOW() - INTERVAL ? DAY",
+		getIntEnvVar("SERVER_RETENTION_DAYS", 14))
+	if err != nil {
+		log.Println("Error fetching deleted servers: ", err)
+		return
+	}
+
+	var servers []deletedServer
+	for rows.Next() {
+		var server deletedServer
+		err = rows.Scan(&server.id, &server.usersId, &server.vCenterID, &server.name)
+		if err != nil {
+			log.Println("Error scanning deleted server: ", err)
+			continue
+		}
+		servers = append(servers, server)
+	}
+	rows.Close()
+
+	for _, server := range servers {
+		// the owner is often gone from LDAP by now, so this doesn't depend on it
+		studentID, err := getServerStudentID(db, server.id, server.usersId)
+		if err != nil {
+			logErrorInDB(fmt.Errorf("purging deleted server %d failed: %v", server.id, err))
+			log.Println("Error fetching owner of server: ", err)
+			continue
+		}
+
+		err = teardownServer(server.id, server.vCenterID, server.name, studentID, db)
+		if err != nil {
+			logErrorInDB(fmt.Errorf("purging deleted server %d failed: %v", server.id, err))
+			log.Println("Error purging deleted server: ", err)
+		}
+	}
+}
+
+// getServerStudentID returns the student ID the VM and the Sophos objects of the server are named after. Servers made
+// before it was stored on the server use LDAP, and when the owner is gone from there the student ID of its last
+// provisioning job
+func getServerStudentID(db *sql.DB, serverID int, usersId string) (string, error) {
+	var studentID string
+	err := db.QueryRow("SELECT student_id FROM virtual_machines WHERE id = ?", serverID).Scan(&studentID)
+	if err != nil {
+		return "", err
+	}
+	if studentID != "" {
+		return studentID, nil
+	}
+
+	studentID, err = getStudentIDWithSID(usersId)
+	if err == nil && studentID != "" {
+		return studentID, nil
+	}
+
+	err = db.QueryRow("SELECT student_id FROM provisioning_jobs WHERE server_id = ? ORDER BY id DESC LIMIT 1", serverID).Scan(&studentID)
+	if err != nil {
+		return "", fmt.Errorf("can't find the student ID of server %d: %v", serverID, err)
+	}
+
+	return studentID, nil
+}
+
+// teardownServer removes the server from the DNS, the database, the IP pool, Sophos and vCenter,
+// the returned error is safe to show to the user
+func teardownServer(id int, vCenterID, serverName, studentID string, db *sql.DB) error {
+	var ipv6 string
+	err := db.QueryRow("SELECT ipv6 FROM virtual_machines WHERE id = ?", id).Scan(&ipv6)
+	if err != nil {
+		log.Println("Error fetching server: ", err)
+		return fmt.Errorf("Can't find server with that ID")
+	}
+
+	// delete all the DNS records for the server
+	err = deleteDNSRecordsForServer(id, db)
+	if err != nil {
+		log.Println("Error deleting DNS records for server: ", err)
+		return fmt.Errorf("Error deleting DNS records for server")
+	}
+
+	// the data disks themselves are removed by vCenter together with the VM
+	_, err = db.Exec("DELETE FROM server_disks WHERE virtual_machines_id = ?", id)
+	if err != nil {
+		log.Println("Error deleting data disks of server: ", err)
+		return fmt.Errorf("Error deleting server from database")
+	}
+
 	// Prepare statement for deleting data
 	stmt, err := db.Prepare("DELETE FROM virtual_machines WHERE id = ?")
 	if err != nil {
 		log.Println("Error preparing statement: ", err)
+		return fmt.Errorf("Error deleting server from database")
 	}
+	defer stmt.Close()
 
 	_, err = stmt.Exec(id)
 	if err != nil {
 		log.Println("Error executing statement: ", err)
-		return c.JSON(http.StatusBadRequest, "Error deleting server from database")
+		return fmt.Errorf("Error deleting server from database")
 	}
 
 	err = unassignIPfromVM(vCenterID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, "Error unassigning IP from VM")
+		return fmt.Errorf("Error unassigning IP from VM")
 	}
 
 	// delete the server from sophos
 	err = removeFirewallFromServerInSophos(studentID, serverName)
 	if err != nil {
 		log.Println("Error removing firewall from sophos: ", err)
-		return c.JSON(http.StatusBadRequest, "Error deleting server from sophos")
+		return fmt.Errorf("Error deleting server from sophos")
 	}
 
-	// delete the server from vCenter
-	session := getVCenterSession()
-	status := deletevCenterVM(session, vCenterID)
+	if ipv6 != "" {
+		err = removeIPv6HostInSophos(studentID, serverName)
+		if err != nil {
+			log.Println("Error removing IPv6 host from sophos: ", err)
+			return fmt.Errorf("Error deleting server from sophos")
+		}
+	}
 
-	if !status {
-		return c.JSON(http.StatusBadRequest, "Error deleting server from vCenter")
+	// delete the server from vCenter
+	err = deletevCenterVM(context.Background(), vCenterID)
+	if err != nil {
+		log.Println("Error deleting server from vCenter: ", err)
+		return fmt.Errorf("Error deleting server from vCenter")
 	}
 
-	return c.JSON(http.StatusCreated, "Server deleted!")
+	return nil
 }
 
 func PowerServer(c echo.Context) error {
@@ -245,9 +480,9 @@ func PowerServer(c echo.Context) error {
 	var vCenterID string
 
 	if isAdmin {
-		err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ?", id).Scan(&vCenterID)
+		err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ? AND deleted_at IS NULL", id).Scan(&vCenterID)
 	} else {
-		err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ? and users_id = ?", id, userId).Scan(&vCenterID)
+		err = db.QueryRow("SELECT vcenter_id FROM virtual_machines WHERE id = ? and users_id = ? AND deleted_at IS NULL", id, userId).Scan(&vCenterID)
 	}
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
@@ -255,35 +490,39 @@ func PowerServer(c echo.Context) error {
 
 	log.Println("vCenterID: ", vCenterID)
 
-	session := getVCenterSession()
+	ctx := c.Request().Context()
 	status = strings.ToUpper(status)
 
 	switch status {
 	case "ON":
 		{
-			success := powerOn(session, vCenterID)
-			if !success {
+			err = powerOn(ctx, vCenterID)
+			if err != nil {
+				log.Println("Error powering on server: ", err)
 				return c.JSON(http.StatusBadRequest, "Error powering on server")
 			}
 		}
 	case "OFF":
 		{
-			success := powerOff(session, vCenterID)
-			if !success {
+			err = powerOff(ctx, vCenterID)
+			if err != nil {
+				log.Println("Error powering off server: ", err)
 				return c.JSON(http.StatusBadRequest, "Error powering off server")
 			}
 		}
 	case "FORCE_OFF":
 		{
-			success := forcePowerOff(session, vCenterID)
-			if !success {
+			err = forcePowerOff(ctx, vCenterID)
+			if err != nil {
+				log.Println("Error powering off server: ", err)
 				return c.JSON(http.StatusBadRequest, "Error powering off server")
 			}
 		}
 	case "RESET":
 		{
-			success := reset(session, vCenterID)
-			if !success {
+			err = reset(ctx, vCenterID)
+			if err != nil {
+				log.Println("Error resetting server: ", err)
 				return c.JSON(http.StatusBadRequest, "Error resetting server")
 			}
 		}
@@ -308,9 +547,6 @@ func CreateServer(c echo.Context) error {
 		log.Println("Error connecting to database: ", err)
 	}
 
-	session := getVCenterSession()
-	serverCreationStep := ""
-
 	if jsonBody.SubDomain != nil && jsonBody.DomainZone != nil {
 		// parse the subdomain and domain zone to regular strings
 		zone := *jsonBody.DomainZone
@@ -324,18 +560,59 @@ func CreateServer(c echo.Context) error {
 		}
 	}
 
-	valid, errMessage, endDate := validateServerCreation(jsonBody, session)
+	// a hardware profile replaces the memory and storage that were sent, the CPU count can only be picked with a profile
+	jsonBody.Cpu = 0
+	if jsonBody.Profile != nil {
+		profile, err := getHardwareProfileByName(db, *jsonBody.Profile)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid hardware profile")
+		}
+		jsonBody.Cpu, jsonBody.Memory, jsonBody.Storage = profile.Cpu, profile.Memory, profile.Storage
+	}
+
+	valid, errMessage, endDate := validateServerCreation(c.Request().Context(), jsonBody)
 	if !valid {
 		return c.JSON(http.StatusBadRequest, errMessage)
 	}
 
 	UserId, isAdmin, fullName, studentID := getUserAssociatedWithJWT(c)
 
-	globalLimit := getEnvVar("GLOBAL_SERVER_LIMIT")
-	intGlobalLimit, _ := strconv.Atoi(globalLimit)
+	// the server is owned by the given user as if they made it themselves
+	if jsonBody.Owner != nil {
+		if !isAdmin {
+			return c.JSON(http.StatusForbidden, "Only admins can make servers for other users")
+		}
+
+		owner, err := fetchUserWithIdentifier(*jsonBody.Owner)
+		if err != nil {
+			log.Println("Error fetching owner: ", err)
+			return c.JSON(http.StatusBadRequest, "Can't find the owner")
+		}
+		UserId, fullName, studentID = owner.SID, owner.FullName, owner.StudentID
+	}
+
+	if !isAdmin {
+		// the lock is released when this returns, the server row is saved by then
+		quotaTx, err := lockQuota(db, UserId)
+		if err != nil {
+			log.Println("Error locking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+		}
+		defer quotaTx.Rollback()
+
+		extra := Quota{Servers: 1, Memory: jsonBody.Memory, Storage: jsonBody.Storage}
+		if jsonBody.SubDomain != nil && jsonBody.DomainZone != nil {
+			extra.DNSRecords = 1
+		}
 
-	if countServersByUser(UserId, db) >= intGlobalLimit && !isAdmin {
-		return c.JSON(http.StatusBadRequest, "You already have 2 servers, you can't create more")
+		quotaMessage, err := checkQuota(quotaTx, UserId, extra)
+		if err != nil {
+			log.Println("Error checking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+		}
+		if quotaMessage != "" {
+			return c.JSON(http.StatusBadRequest, quotaMessage)
+		}
 	}
 
 	serverAlreadyExists := checkIfUserAlreadyHasServerWithName(jsonBody.Name, UserId, db)
@@ -343,172 +620,256 @@ func CreateServer(c echo.Context) error {
 		return c.JSON(http.StatusConflict, "You're already using this name!")
 	}
 
-	ip := findEmptyIp()
-	if ip == "" {
+	network := getNetworkForServerCreation(jsonBody)
+	_, err = getNetworkByName(db, network)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid network")
+	}
+	jsonBody.Network = &network
+
+	ip, err := allocateIp(network, 4)
+	if err != nil {
+		log.Println("Error allocating IP: ", err)
 		return c.JSON(http.StatusBadRequest, "No IP addresses available")
 	}
 
-	err = claimIp(ip)
+	// IPv6 is still being rolled out, so servers on a network without free IPv6 addresses only get IPv4
+	ipv6, err := allocateIp(network, 6)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, "Error claiming IP")
+		log.Println("No IPv6 address allocated: ", err)
+		ipv6 = ""
 	}
 
-	err = createServerInDB(UserId, jsonBody, endDate, db)
+	serverID, err := createServerInDB(UserId, studentID, jsonBody, endDate, db)
 	if err != nil {
 		log.Println("Error creating server: ", err)
+		unclaimIp(ip)
+		unclaimIp(ipv6)
+		return c.JSON(http.StatusInternalServerError, "Error creating server")
+	}
+
+	// the rest of the creation is done by a provisioning job that is stored in the database,
+	// so it can be picked up again if the API gets restarted while the server is being made
+	jobID, err := createProvisioningJob(db, &provisioningJob{
+		Kind:      "create",
+		UsersId:   UserId,
+		StudentID: studentID,
+		FullName:  fullName,
+		Request:   *jsonBody,
+		IP:        ip,
+		IPv6:      ipv6,
+		ServerId:  serverID,
+	})
+	if err != nil {
+		log.Println("Error creating provisioning job: ", err)
+		deleteServerFromDB(jsonBody.Name, UserId, db)
+		unclaimIp(ip)
+		unclaimIp(ipv6)
+		return c.JSON(http.StatusInternalServerError, "Error creating server")
 	}
-	serverCreationStep = "made in db"
 
-	go func() {
-		var vCenterID, err = createvCenterVM(session, studentID, jsonBody.Name, jsonBody.OperatingSystem, jsonBody.Storage, jsonBody.Memory)
-		err = updateServerWithVCenterID(vCenterID, jsonBody.Name, UserId, ip, db)
-		if err != nil {
-			logErrorInDB(err)
-			handleFailedCreation(jsonBody.Name, UserId, studentID, "", serverCreationStep, ip, db)
-			log.Println("Error updating or making server: ", err)
-			log.Println("vCenter ID: ", vCenterID)
-			return
-		}
-		serverCreationStep = "made in vCenter"
+	go runProvisioningJob(jobID)
 
-		err = createFirewallRuleForServerCreation(ip, studentID, jsonBody.Name)
-		if err != nil {
-			logErrorInDB(err)
-			handleFailedCreation(jsonBody.Name, UserId, studentID, vCenterID, serverCreationStep, ip, db)
-			log.Println("Error creating firewall rules: ", err)
-			return
-		}
+	return c.JSON(http.StatusCreated, map[string]interface{}{"message": "Server is being made!", "job_id": jobID})
+}
 
-		serverCreationStep = "made in sophos"
+var validServerName = regexp.MustCompile("^[A-Za-z0-9-]{1,100}$")
 
-		err = assignIPToVM(ip, vCenterID)
-		if err != nil {
-			logErrorInDB(err)
-			handleFailedCreation(jsonBody.Name, UserId, studentID, vCenterID, serverCreationStep, ip, db)
-			log.Println("Error assigning IP to VM: ", err)
-			return
-		}
+func validateServerCreation(ctx context.Context, json *serverCreationJsonBody) (bool, string, time.Time) {
+	// check if the date is in the correct format (YYYY-MM-DD)
+	var endDate, errDate = time.Parse("2006-01-02", json.EndDate)
+	if errDate != nil {
+		return false, "Invalid date format, please use YYYY-MM-DD", time.Time{}
+	}
 
-		serverCreationStep = "made in ip"
+	// check if the end date is in the future
+	if endDate.Before(time.Now()) {
+		return false, "End date in the past", time.Time{}
+	}
 
-		err = addUsersToFirewall(studentID, *jsonBody)
-		if err != nil {
-			logErrorInDB(err)
-			handleFailedCreation(jsonBody.Name, UserId, studentID, vCenterID, serverCreationStep, ip, db)
-			log.Println("Error adding ips to firewall: ", err)
-			return
-		}
+	// check if the OS exist
+	templates := getTemplatesFromVCenter(ctx)
+	if !checkIfItemIsKeyOfArray(json.OperatingSystem, templates) {
+		return false, "Invalid operating system", time.Time{}
+	}
 
-		powerOn(session, vCenterID)
+	if json.Memory < 1 || json.Storage < 1 {
+		return false, "Memory and storage have to be at least 1 GB", time.Time{}
+	}
 
-		startScript, err := readStartScript(jsonBody.OperatingSystem)
-		if err != nil {
-			log.Println("Error running script in VM: ", err)
-		}
+	// remove spaces from the name
+	json.Name = strings.ReplaceAll(json.Name, " ", "")
+
+	// the name ends up in the hostname, the VM name, the Sophos objects and the arguments of the start script
+	if !validServerName.MatchString(json.Name) {
+		return false, "The name can only contain letters, numbers and dashes", time.Time{}
+	}
 
-		time.Sleep(30 * time.Second)
+	return true, "", endDate
+}
+
+func UpdateServer(c echo.Context) error {
+	id := c.Param("id")
+	jsonBody := new(serverUpdateJsonBody)
+	err := c.Bind(jsonBody)
+	if err != nil {
+		log.Println("Error binding JSON: ", err)
+		return c.JSON(http.StatusBadRequest, "Invalid JSON")
+	}
 
-		// Get only the first name of the user
-		firstName := strings.Split(fullName, " ")[0]
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+	}
+	defer db.Close()
 
-		err = runStartScript(session, startScript, firstName, studentID, vCenterID, ip, jsonBody.Name)
+	userId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+
+	var (
+		vCenterID       string
+		storage, memory int
+	)
+
+	if isAdmin {
+		err = db.QueryRow("SELECT vcenter_id, storage, memory FROM virtual_machines WHERE id = ? AND deleted_at IS NULL", id).Scan(&vCenterID, &storage, &memory)
+	} else {
+		err = db.QueryRow("SELECT vcenter_id, storage, memory FROM virtual_machines WHERE id = ? and users_id = ? AND deleted_at IS NULL", id, userId).Scan(&vCenterID, &storage, &memory)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Can't find server with that ID")
+	}
+
+	if vCenterID == "" && (jsonBody.Memory != nil || jsonBody.Storage != nil) {
+		return c.JSON(http.StatusConflict, "The server is still being made, try again later")
+	}
+
+	newMemory, newStorage := memory, storage
+	if jsonBody.Memory != nil {
+		newMemory = *jsonBody.Memory
+	}
+	if jsonBody.Storage != nil {
+		newStorage = *jsonBody.Storage
+	}
+
+	// disks can't be shrunk, so we only allow growing the server
+	if newMemory < memory || newStorage < storage {
+		return c.JSON(http.StatusBadRequest, "Memory and storage can only be increased")
+	}
+
+	if !isAdmin {
+		// held while vCenter changes the server, until the new memory and storage are saved
+		quotaTx, err := lockQuota(db, userId)
 		if err != nil {
-			logErrorInDB(err)
-			handleFailedCreation(jsonBody.Name, UserId, studentID, vCenterID, serverCreationStep, ip, db)
-			log.Println("Error running script in VM: ", err)
-			return
+			log.Println("Error locking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
 		}
+		defer quotaTx.Rollback()
 
-		_, _, _, studentEmail, err := fetchUserInfoWithSID(UserId)
+		quotaMessage, err := checkQuota(quotaTx, userId, Quota{Memory: newMemory - memory, Storage: newStorage - storage})
 		if err != nil {
-			log.Println("Error fetching user info: ", err)
+			log.Println("Error checking quota: ", err)
+			return c.JSON(http.StatusInternalServerError, "error in the program, please try again later")
+		}
+		if quotaMessage != "" {
+			return c.JSON(http.StatusBadRequest, quotaMessage)
 		}
+	}
 
-		if jsonBody.SubDomain != nil && jsonBody.DomainZone != nil {
-			// convert the subdomain and domain zone to regular strings
-			zone := *jsonBody.DomainZone
-			subdomain := *jsonBody.SubDomain + "." + getEnvVar("SUBDOMAIN_PREFIX")
+	var endDate time.Time
+	if jsonBody.EndDate != nil {
+		// check if the date is in the correct format (YYYY-MM-DD)
+		endDate, err = time.Parse("2006-01-02", *jsonBody.EndDate)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid date format, please use YYYY-MM-DD")
+		}
 
-			// strip any dots from the subdomain to make sure it's a parent
-			subdomain = strings.TrimSuffix(subdomain, ".")
+		if endDate.Before(time.Now()) {
+			return c.JSON(http.StatusBadRequest, "End date in the past")
+		}
+	}
 
-			// get the id of the server we just created
-			var serverID string
-			err = db.QueryRow("SELECT id FROM virtual_machines WHERE name = ? AND users_id = ? and vcenter_id = ?", jsonBody.Name, UserId, vCenterID).Scan(&serverID)
-			if err != nil {
-				log.Println("Error getting server ID: ", err)
-				handleFailedCreation(jsonBody.Name, UserId, studentID, vCenterID, serverCreationStep, ip, db)
-			}
+	ctx := c.Request().Context()
 
-			// create the DNS record
-			err = createDNSRecord(db, subdomain, zone, ip, strconv.Itoa(3306), "A", serverID)
+	// every change is saved as soon as vCenter made it, so the quota stays right when a later change fails
+	if newMemory != memory {
+		err = updatevCenterVMMemory(ctx, vCenterID, newMemory)
+		if err != nil {
+			log.Println("Error updating memory in vCenter: ", err)
+			return c.JSON(http.StatusBadRequest, "Error updating memory, try again with the server powered off")
 		}
 
-		serverCreationSuccessTitle := "Server is gemaakt"
-		serverCreationSuccessBody := "Je server(" + jsonBody.Name + ") is gemaakt met het ip: " + ip + " je gebruikersnaam is: " + firstName + " en je wachtwoord is: " + firstName + " verander dit aub zo snel mogelijk! Als je een subdomein hebt aangevraagd dan is deze ook aangemaakt kan je deze nu ook gebruiken."
-		// check if the email is not empty
-		createNotificationForUser(db, UserId, serverCreationSuccessTitle, serverCreationSuccessBody)
-		if studentEmail != "" {
-			sendEmailNotification(studentEmail, serverCreationSuccessTitle, serverCreationSuccessBody)
+		_, err = db.Exec("UPDATE virtual_machines SET memory = ?, updated_at = NOW() WHERE id = ?", newMemory, id)
+		if err != nil {
+			log.Println("Error updating memory in database: ", err)
+			return c.JSON(http.StatusInternalServerError, "Error updating server in database")
 		}
-	}()
+	}
 
-	return c.JSON(http.StatusCreated, "Server is being made!")
-}
+	if newStorage != storage {
+		err = updatevCenterVMDiskCapacity(ctx, vCenterID, vCenterRootDiskID, newStorage)
+		if err != nil {
+			log.Println("Error updating storage in vCenter: ", err)
+			return c.JSON(http.StatusBadRequest, "Error updating storage")
+		}
 
-func validateServerCreation(json *serverCreationJsonBody, session string) (bool, string, time.Time) {
-	// check if the date is in the correct format (YYYY-MM-DD)
-	var endDate, errDate = time.Parse("2006-01-02", json.EndDate)
-	if errDate != nil {
-		return false, "Invalid date format, please use YYYY-MM-DD", time.Time{}
+		_, err = db.Exec("UPDATE virtual_machines SET storage = ?, updated_at = NOW() WHERE id = ?", newStorage, id)
+		if err != nil {
+			log.Println("Error updating storage in database: ", err)
+			return c.JSON(http.StatusInternalServerError, "Error updating server in database")
+		}
 	}
 
-	// check if the end date is in the future
-	if endDate.Before(time.Now()) {
-		return false, "End date in the past", time.Time{}
+	// only update the columns that were sent so the rest of the row stays the same
+	query := "UPDATE virtual_machines SET updated_at = NOW()"
+	args := []interface{}{}
+	if jsonBody.Description != nil {
+		query += ", description = ?"
+		args = append(args, *jsonBody.Description)
 	}
-
-	// check if the OS exist
-	templates := getTemplatesFromVCenter(session)
-	if !checkIfItemIsKeyOfArray(json.OperatingSystem, templates) {
-		return false, "Invalid operating system", time.Time{}
+	if jsonBody.EndDate != nil {
+		query += ", end_date = ?"
+		args = append(args, endDate)
 	}
+	query += " WHERE id = ?"
+	args = append(args, id)
 
-	if json.Memory > 2 || json.Storage > 20 {
-		return false, "NUH UH", time.Time{}
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		log.Println("Error updating server: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error updating server in database")
 	}
 
-	// remove spaces from the name
-	json.Name = strings.ReplaceAll(json.Name, " ", "")
-
-	return true, "", endDate
+	return c.JSON(http.StatusOK, "Server updated!")
 }
 
-func createServerInDB(UserId string, json *serverCreationJsonBody, endDate time.Time, db *sql.DB) error {
+// createServerInDB also stores the student ID of the owner, the VM and the Sophos objects are named after it
+// and it has to be known to remove them after the owner is gone from LDAP
+func createServerInDB(UserId, studentID string, json *serverCreationJsonBody, endDate time.Time, db *sql.DB) (int64, error) {
 	// Insert the new server into the database
-	stmt, err := db.Prepare("INSERT INTO virtual_machines(users_id, vcenter_id, name, description, end_date, operating_system, storage, memory, ip) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := db.Prepare("INSERT INTO virtual_machines(users_id, student_id, vcenter_id, name, description, end_date, operating_system, cpu, storage, memory, ip) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(UserId, "", json.Name, json.Description, endDate, json.OperatingSystem, json.Storage, json.Memory, "")
+	result, err := stmt.Exec(UserId, studentID, "", json.Name, json.Description, endDate, json.OperatingSystem, json.Cpu, json.Storage, json.Memory, "")
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return result.LastInsertId()
 }
 
-func updateServerWithVCenterID(vCenterID, name, userID, ip string, db *sql.DB) error {
+func updateServerWithVCenterID(vCenterID, name, userID, ip, ipv6 string, db *sql.DB) error {
 	// Update the vCenter ID in the database
-	stmt, err := db.Prepare("UPDATE virtual_machines SET vcenter_id = ?, ip = ? WHERE name = ? and users_id = ?")
+	stmt, err := db.Prepare("UPDATE virtual_machines SET vcenter_id = ?, ip = ?, ipv6 = ? WHERE name = ? and users_id = ? AND deleted_at IS NULL")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(vCenterID, ip, name, userID)
+	_, err = stmt.Exec(vCenterID, ip, ipv6, name, userID)
 	if err != nil {
 		return err
 	}
@@ -516,7 +877,8 @@ func updateServerWithVCenterID(vCenterID, name, userID, ip string, db *sql.DB) e
 	return nil
 }
 
-func createFirewallRuleForServerCreation(ip, studentID, serverName string) error {
+// ipv6 is optional, when it is empty only the IPv4 host is made
+func createFirewallRuleForServerCreation(ip, ipv6, studentID, serverName, zone string) error {
 	defer timeTrack(time.Now(), "createFirewallRuleForServerCreation")
 	parseAndSetIpListForSophos()
 	err := createIPHostInSopohos(ip, studentID, serverName)
@@ -524,9 +886,22 @@ func createFirewallRuleForServerCreation(ip, studentID, serverName string) error
 		log.Println("Error creating IP host: ", err)
 		return err
 	}
-	err = createSophosFirewallRules(studentID, serverName)
+
+	if ipv6 != "" {
+		err = createIPv6HostInSophos(ipv6, studentID, serverName)
+		if err != nil {
+			log.Println("Error creating IPv6 host: ", err)
+			removeIPHostInSophos(studentID, serverName)
+			return err
+		}
+	}
+
+	err = createSophosFirewallRules(studentID, serverName, zone, ipv6 != "")
 	if err != nil {
 		removeIPHostInSophos(studentID, serverName)
+		if ipv6 != "" {
+			removeIPv6HostInSophos(studentID, serverName)
+		}
 
 		log.Println("Error creating firewall rules: ", err)
 		return err
@@ -539,6 +914,9 @@ func createFirewallRuleForServerCreation(ip, studentID, serverName string) error
 		removeIPHostInSophos(studentID, serverName)
 		removeInBoundRuleInSophos(studentID, serverName)
 		removeOutBoundRuleInSophos(studentID, serverName)
+		if ipv6 != "" {
+			removeIPv6HostInSophos(studentID, serverName)
+		}
 
 		return err
 	}
@@ -598,27 +976,43 @@ func readStartScript(templateName string) (startScript, error) {
 	return script, nil
 }
 
-func handleFailedCreation(serverName, userId, studentId, vCenterId, serverCreationStep, ip string, db *sql.DB) {
+func handleFailedCreation(serverName, userId, studentId, vCenterId, serverCreationStep, ip, ipv6 string, db *sql.DB) {
 	logErrorInDB(fmt.Errorf("Server creation failed for server: " + serverName + " got stuck at: " + serverCreationStep))
 	err := unassignIPfromVM(vCenterId)
 	if err != nil {
 		log.Println("Error unassigning IP from VM: ", err)
 	}
 
+	// the IP is only claimed when the VM was never made, so also free it up by the IP itself
+	err = unclaimIp(ip)
+	if err != nil {
+		log.Println("Error unclaiming IP: ", err)
+	}
+	err = unclaimIp(ipv6)
+	if err != nil {
+		log.Println("Error unclaiming IPv6: ", err)
+	}
+
 	if serverCreationStep == "made in db" {
 		deleteServerFromDB(serverName, userId, db)
 	}
 
 	if serverCreationStep == "made in vCenter" {
 		deleteServerFromDB(serverName, userId, db)
-		deletevCenterVM(getVCenterSession(), vCenterId)
+		err := deletevCenterVM(context.Background(), vCenterId)
+		if err != nil {
+			log.Println("Error deleting VM in vCenter: ", err)
+		}
 	}
 
 	if serverCreationStep == "made in sophos" {
 		deleteServerFromDB(serverName, userId, db)
-		deletevCenterVM(getVCenterSession(), vCenterId)
+		err := deletevCenterVM(context.Background(), vCenterId)
+		if err != nil {
+			log.Println("Error deleting VM in vCenter: ", err)
+		}
 
-		err := removeIPHostInSophos(studentId, serverName)
+		err = removeIPHostInSophos(studentId, serverName)
 		if err != nil {
 			log.Println("Error removing IP host in Sophos: ", err)
 		}
@@ -630,13 +1024,22 @@ func handleFailedCreation(serverName, userId, studentId, vCenterId, serverCreati
 		if err != nil {
 			log.Println("Error removing outbound rule in Sophos: ", err)
 		}
+		if ipv6 != "" {
+			err = removeIPv6HostInSophos(studentId, serverName)
+			if err != nil {
+				log.Println("Error removing IPv6 host in Sophos: ", err)
+			}
+		}
 	}
 
 	if serverCreationStep == "made in ip" {
 		deleteServerFromDB(serverName, userId, db)
-		deletevCenterVM(getVCenterSession(), vCenterId)
+		err := deletevCenterVM(context.Background(), vCenterId)
+		if err != nil {
+			log.Println("Error deleting VM in vCenter: ", err)
+		}
 
-		err := removeIPHostInSophos(studentId, serverName)
+		err = removeIPHostInSophos(studentId, serverName)
 		if err != nil {
 			log.Println("Error removing IP host in Sophos: ", err)
 		}
@@ -648,6 +1051,12 @@ func handleFailedCreation(serverName, userId, studentId, vCenterId, serverCreati
 		if err != nil {
 			log.Println("Error removing outbound rule in Sophos: ", err)
 		}
+		if ipv6 != "" {
+			err = removeIPv6HostInSophos(studentId, serverName)
+			if err != nil {
+				log.Println("Error removing IPv6 host in Sophos: ", err)
+			}
+		}
 	}
 
 	userErrorTitle := "Error bij server maken"
@@ -689,7 +1098,7 @@ func checkIfUserAlreadyHasServerWithName(name, userID string, db *sql.DB) bool {
 }
 
 func checkIfServerExistsInDB(id string, db *sql.DB) bool {
-	rows, err := db.Query("SELECT id FROM virtual_machines WHERE id = ?", id)
+	rows, err := db.Query("SELECT id FROM virtual_machines WHERE id = ? AND deleted_at IS NULL", id)
 	if err != nil {
 		log.Println("Could not check if the server exists err: ", err)
 		return true
@@ -702,8 +1111,24 @@ func checkIfServerExistsInDB(id string, db *sql.DB) bool {
 	return true
 }
 
+// getServerForUser returns the vCenter ID and the owner of the server in the id param,
+// admins can get every server and users only their own
+func getServerForUser(c echo.Context, db *sql.DB) (string, string, error) {
+	userId, isAdmin, _, _ := getUserAssociatedWithJWT(c)
+
+	var vCenterID, ownerId string
+	var err error
+	if isAdmin {
+		err = db.QueryRow("SELECT vcenter_id, users_id FROM virtual_machines WHERE id = ? AND deleted_at IS NULL", c.Param("id")).Scan(&vCenterID, &ownerId)
+	} else {
+		err = db.QueryRow("SELECT vcenter_id, users_id FROM virtual_machines WHERE id = ? and users_id = ? AND deleted_at IS NULL", c.Param("id"), userId).Scan(&vCenterID, &ownerId)
+	}
+
+	return vCenterID, ownerId, err
+}
+
 func checkIfServerBelongsToUser(serverID, userID string, db *sql.DB) bool {
-	rows, err := db.Query("SELECT id FROM virtual_machines WHERE id = ? AND users_id = ?", serverID, userID)
+	rows, err := db.Query("SELECT id FROM virtual_machines WHERE id = ? AND users_id = ? AND deleted_at IS NULL", serverID, userID)
 	if err != nil {
 		log.Println("Could not check if the server belongs to the user err: ", err)
 		return false
@@ -715,18 +1140,3 @@ func checkIfServerBelongsToUser(serverID, userID string, db *sql.DB) bool {
 
 	return true
 }
-
-func countServersByUser(userID string, db *sql.DB) int {
-	rows, err := db.Query("SELECT id FROM virtual_machines WHERE users_id = ?", userID)
-	if err != nil {
-		log.Println("Could not count the servers by user err: ", err)
-		return 0
-	}
-
-	var count int
-	for rows.Next() {
-		count++
-	}
-
-	return count
-}
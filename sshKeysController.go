@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// the most SSH keys a user can have, all of them are put on every new server
+const maxSshKeysPerUser = 10
+
+type SshKey struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func GetMySshKeys(c echo.Context) error {
+	userId, _, _, _ := getUserAssociatedWithJWT(c)
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	keys, err := getSshKeysForUser(db, userId)
+	if err != nil {
+		log.Println("Error fetching SSH keys: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to fetch SSH keys")
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+func CreateMySshKey(c echo.Context) error {
+	userId, _, _, _ := getUserAssociatedWithJWT(c)
+
+	var key SshKey
+	if err := c.Bind(&key); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid request")
+	}
+
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" || len(key.Name) > 100 {
+		return c.JSON(http.StatusBadRequest, "An SSH key needs a name of at most 100 characters")
+	}
+
+	publicKey, fingerprint, err := parseSshPublicKey(key.PublicKey)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	key.PublicKey = publicKey
+	key.Fingerprint = fingerprint
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM ssh_keys WHERE users_id = ?", userId).Scan(&count)
+	if err != nil {
+		log.Println("Error counting SSH keys: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to add SSH key")
+	}
+	if count >= maxSshKeysPerUser {
+		return c.JSON(http.StatusBadRequest, "You can't have more SSH keys, remove one first")
+	}
+
+	result, err := db.Exec("INSERT INTO ssh_keys (users_id, name, public_key, fingerprint) VALUES (?, ?, ?, ?)", userId, key.Name, key.PublicKey, key.Fingerprint)
+	if err != nil {
+		log.Println("Error adding SSH key: ", err)
+		return c.JSON(http.StatusBadRequest, "Failed to add SSH key, it might already be added")
+	}
+
+	key.ID, _ = result.LastInsertId()
+
+	return c.JSON(http.StatusCreated, key)
+}
+
+// DeleteMySshKey only removes the key from the account, servers that were made with it keep it in authorized_keys
+func DeleteMySshKey(c echo.Context) error {
+	userId, _, _, _ := getUserAssociatedWithJWT(c)
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Println("Error connecting to database: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to connect to database")
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM ssh_keys WHERE id = ? AND users_id = ?", c.Param("id"), userId)
+	if err != nil {
+		log.Println("Error deleting SSH key: ", err)
+		return c.JSON(http.StatusInternalServerError, "Failed to delete SSH key")
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return c.JSON(http.StatusNotFound, "SSH key not found")
+	}
+
+	return c.JSON(http.StatusOK, "SSH key deleted")
+}
+
+// parseSshPublicKey checks a key in the authorized_keys format and returns it without options,
+// together with its SHA256 fingerprint like ssh-keygen -l shows it
+func parseSshPublicKey(publicKey string) (string, string, error) {
+	key, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
+	if err != nil {
+		return "", "", errors.New("Invalid SSH public key")
+	}
+	if len(rest) > 0 {
+		return "", "", errors.New("Only one SSH public key can be added at a time")
+	}
+	// options like command= would end up in authorized_keys of the server
+	if len(options) > 0 {
+		return "", "", errors.New("SSH public keys with options are not allowed")
+	}
+
+	switch key.Type() {
+	case ssh.KeyAlgoDSA:
+		return "", "", errors.New("DSA keys are not supported, use an ed25519 or RSA key")
+	case ssh.KeyAlgoRSA:
+		cryptoKey, ok := key.(ssh.CryptoPublicKey)
+		if !ok {
+			return "", "", errors.New("Invalid SSH public key")
+		}
+		if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); !ok || rsaKey.N.BitLen() < 2048 {
+			return "", "", errors.New("RSA keys need to be at least 2048 bits")
+		}
+	}
+
+	normalized := key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+	if comment != "" {
+		normalized += " " + comment
+	}
+
+	return normalized, ssh.FingerprintSHA256(key), nil
+}
+
+func getSshKeysForUser(db *sql.DB, userId string) ([]SshKey, error) {
+	rows, err := db.Query("SELECT id, name, public_key, fingerprint, created_at FROM ssh_keys WHERE users_id = ? ORDER BY id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SshKey{}
+	for rows.Next() {
+		var key SshKey
+		err = rows.Scan(&key.ID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// getAuthorizedKeysForUser returns the public keys of the user as the lines of authorized_keys
+func getAuthorizedKeysForUser(db *sql.DB, userId string) []string {
+	keys, err := getSshKeysForUser(db, userId)
+	if err != nil {
+		log.Println("Error fetching SSH keys: ", err)
+		return nil
+	}
+
+	var authorizedKeys []string
+	for _, key := range keys {
+		authorizedKeys = append(authorizedKeys, key.PublicKey)
+	}
+
+	return authorizedKeys
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func authorizedKey(t *testing.T, key interface{}) string {
+	publicKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+}
+
+func TestParseSshPublicKey(t *testing.T) {
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed25519Line := authorizedKey(t, ed25519Key)
+	rsaLine := authorizedKey(t, &rsaKey.PublicKey)
+
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr string
+	}{
+		{name: "ed25519 key", key: ed25519Line, want: ed25519Line},
+		{name: "comment is kept", key: ed25519Line + " student@laptop", want: ed25519Line + " student@laptop"},
+		{name: "whitespace around the key", key: "\n  " + ed25519Line + "  \n", want: ed25519Line},
+		{name: "RSA key of 2048 bits", key: rsaLine, want: rsaLine},
+		{name: "RSA key of 1024 bits", key: authorizedKey(t, &smallRsaKey.PublicKey), wantErr: "RSA keys need to be at least 2048 bits"},
+		{name: "options", key: `command="rm -rf /" ` + ed25519Line, wantErr: "SSH public keys with options are not allowed"},
+		{name: "two keys", key: ed25519Line + "\n" + rsaLine, wantErr: "Only one SSH public key can be added at a time"},
+		{name: "not a key", key: "ssh-ed25519 bm90IGEga2V5", wantErr: "Invalid SSH public key"},
+		{name: "empty", key: "", wantErr: "Invalid SSH public key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, fingerprint, err := parseSshPublicKey(test.key)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("got key %q, want %q", got, test.want)
+			}
+			if !strings.HasPrefix(fingerprint, "SHA256:") {
+				t.Errorf("got fingerprint %q, want a SHA256 fingerprint", fingerprint)
+			}
+		})
+	}
+}
@@ -6,8 +6,7 @@ import (
 )
 
 func GetTemplates(c echo.Context) error {
-	session := getVCenterSession()
-	templates := getTemplatesFromVCenter(session)
+	templates := getTemplatesFromVCenter(c.Request().Context())
 	return c.JSON(http.StatusOK, templates)
 }
 
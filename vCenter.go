@@ -1,13 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
-	"errors"
-	"io"
+	"context"
+	"encoding/base64"
+	"fmt"
 	"log"
-	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,60 +14,38 @@ import (
 type vCenterTemplates struct {
 }
 
-func createVCenterHTTPClient() *http.Client {
-	// Create a Transport for our client so we can skip SSL verification because the vCenter certificate is self-signed
-	tlsConfig := &tls.Config{InsecureSkipVerify: !getBoolEnvVar("VERIFY_TLS")}
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
+// listvCenterVMs returns all the VMs in vCenter, when vCenter can't be reached it returns an error
+// so an empty list can be trusted
+func listvCenterVMs(ctx context.Context) ([]vCenterServers, error) {
+	defer timeTrack(time.Now(), "listvCenterVMs")
 
-	// Create client with the Transport that can skip SSL verification if needed
-	client := &http.Client{Transport: transport}
+	var servers []vCenterServers
+	err := vCenter.Do(ctx, "GET", "/api/vcenter/vm", nil, &servers)
 
-	return client
+	return servers, err
 }
 
-// vmID is optional, if it is empty, it will return the power status of all VMs, otherwise it will return the power status of the specified VM
-// if you know how to make this optional please do
-func getPowerStatusFromvCenter(session, vmID string) []vCenterServers {
-	defer timeTrack(time.Now(), "getPowerStatusFromvCenter")
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
-
-	// Create a new request
-	req, err := http.NewRequest("GET", baseURL+"/api/vcenter/vm/"+vmID, nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-	}
-
-	req.Header.Add("vmware-api-session-id", session)
-
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-	}
-
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Error reading response: ", err)
-	}
-
+// getvCenterVMIDByName returns the ID of the VM with the given name, or an empty string if it doesn't exist
+func getvCenterVMIDByName(ctx context.Context, name string) (string, error) {
 	var servers []vCenterServers
-	err = json.Unmarshal(body, &servers)
-	if err != nil {
-		log.Println("Error unmarshalling response: ", err)
+	err := vCenter.Do(ctx, "GET", "/api/vcenter/vm?names="+url.QueryEscape(name), nil, &servers)
+	if err != nil || len(servers) == 0 {
+		return "", err
 	}
 
-	defer resp.Body.Close()
-	return servers
+	return servers[0].Vm, nil
 }
 
-func createvCenterVM(session, studentID, vmName, templateName string, storage, memory int) (string, error) {
+// network is the vCenter network the first NIC is connected to, if it is empty the network of the template is kept,
+// the same goes for cpu when it is 0. customization is nil for templates that are set up by their start script
+func createvCenterVM(ctx context.Context, studentID, vmName, templateName, network string, cpu, storage, memory int, customization *vCenterGuestCustomization) (string, error) {
 	defer timeTrack(time.Now(), "createvCenterVM")
 
 	type HardwareCustomization struct {
-		DisksToUpdate map[string]map[string]int `json:"disks_to_update,omitempty"`
-		MemoryUpdate  map[string]int            `json:"memory_update,omitempty"`
+		CpuUpdate     map[string]int               `json:"cpu_update,omitempty"`
+		DisksToUpdate map[string]map[string]int    `json:"disks_to_update,omitempty"`
+		MemoryUpdate  map[string]int               `json:"memory_update,omitempty"`
+		Nics          map[string]map[string]string `json:"nics,omitempty"`
 	}
 
 	type VMCreateRequest struct {
@@ -79,11 +56,12 @@ func createvCenterVM(session, studentID, vmName, templateName string, storage, m
 		HardwareCustomization HardwareCustomization `json:"hardware_customization,omitempty"`
 	}
 
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
 	templateID := getFromRedis(templateName)
 
-	datastore := getvCenterDataStoreID(session)
+	datastore, err := getvCenterDataStoreID(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	reqBody := VMCreateRequest{
 		Name: "OICT-AUTO-" + studentID + "-" + vmName,
@@ -99,7 +77,7 @@ func createvCenterVM(session, studentID, vmName, templateName string, storage, m
 		},
 		HardwareCustomization: HardwareCustomization{
 			DisksToUpdate: map[string]map[string]int{
-				"2000": {
+				vCenterRootDiskID: {
 					// storage is in GB, so we need to convert it to bytes and add 1 so it is not exactly the same as the template
 					"capacity": storage*1073741824 + 1,
 				},
@@ -110,139 +88,230 @@ func createvCenterVM(session, studentID, vmName, templateName string, storage, m
 		},
 	}
 
-	jsonReqBody, err := json.Marshal(reqBody)
-	if err != nil {
-		log.Println("Error marshalling request body: ", err)
+	if cpu > 0 {
+		reqBody.HardwareCustomization.CpuUpdate = map[string]int{
+			"num_cpus": cpu,
+		}
 	}
 
-	// Create a new request
-	req, err := http.NewRequest("POST", baseURL+"/api/vcenter/vm-template/library-items/"+templateID+"?action=deploy",
-		// body
-		bytes.NewBuffer(jsonReqBody))
-	if err != nil {
-		log.Println("Error creating request: ", err)
+	if network != "" {
+		reqBody.HardwareCustomization.Nics = map[string]map[string]string{
+			vCenterPrimaryNicID: {
+				"network": network,
+			},
+		}
 	}
 
-	req.Header.Add("vmware-api-session-id", session)
-	req.Header.Add("Content-Type", "application/json")
+	// vCenter only answers when the VM is deployed
+	ctx, cancel := withVCenterTaskTimeout(ctx)
+	defer cancel()
 
-	// Send the request
-	resp, err := client.Do(req)
+	var vmID string
+	err = vCenter.Do(ctx, "POST", "/api/vcenter/vm-template/library-items/"+templateID+"?action=deploy", reqBody, &vmID)
 	if err != nil {
-		log.Println("Error sending request: ", err)
+		return "", err
 	}
 
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
+	return vmID, customizeNewvCenterVM(ctx, vmID, customization)
+}
+
+// clonevCenterVM makes a full copy of the source VM including its disks and network, the copy is left powered off
+func clonevCenterVM(ctx context.Context, studentID, vmName, sourceVMID string, customization *vCenterGuestCustomization) (string, error) {
+	defer timeTrack(time.Now(), "clonevCenterVM")
+
+	// vCenter only answers when the VM is cloned
+	ctx, cancel := withVCenterTaskTimeout(ctx)
+	defer cancel()
+
+	var vmID string
+	err := vCenter.Do(ctx, "POST", "/api/vcenter/vm?action=clone", map[string]interface{}{
+		"name":   "OICT-AUTO-" + studentID + "-" + vmName,
+		"source": sourceVMID,
+		"placement": map[string]string{
+			"cluster": getEnvVar("CLUSTER_ID"),
+			"folder":  getEnvVar("FOLDER_ID"),
+		},
+		"power_on": false,
+	}, &vmID)
 	if err != nil {
 		return "", err
 	}
 
-	defer resp.Body.Close()
+	return vmID, customizeNewvCenterVM(ctx, vmID, customization)
+}
 
-	if strings.Contains(string(body[1:len(body)-1]), "\"error_type\":\"ALREADY_EXISTS\"") {
-		return "", errors.New("VM already exists")
+// customizeNewvCenterVM sets the customization of a VM that was just deployed and is still powered off, when that fails
+// the VM is deleted again because it would boot with the config of the template
+func customizeNewvCenterVM(ctx context.Context, vmID string, customization *vCenterGuestCustomization) error {
+	if customization == nil {
+		return nil
 	}
 
-	// remove the " " from the response and convert it to a string of just the VM ID
-	return string(body[1 : len(body)-1]), nil
-}
+	err := setvCenterGuestCustomization(ctx, vmID, customization)
+	if err != nil {
+		deleteErr := deletevCenterVM(ctx, vmID)
+		if deleteErr != nil {
+			log.Println("Error deleting VM that could not be customized: ", deleteErr)
+		}
+	}
 
-func deletevCenterVM(session, vmID string) bool {
-	defer timeTrack(time.Now(), "deletevCenterVM")
+	return err
+}
 
-	success := forcePowerOff(session, vmID)
-	if !success {
-		return false
+// vCenterVMExists only returns false when vCenter says the VM is not there, so a vCenter that can't be reached
+// doesn't make a VM look deleted
+func vCenterVMExists(ctx context.Context, vmID string) bool {
+	err := vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+vmID, nil, nil)
+	if err != nil && !isVCenterError(err, "NOT_FOUND") {
+		log.Println("Error checking if VM exists: ", err)
 	}
 
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
+	return !isVCenterError(err, "NOT_FOUND")
+}
 
-	req, err := http.NewRequest("DELETE", baseURL+"/api/vcenter/vm/"+vmID, nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-	}
+// renamevCenterVM changes the name of the VM, vmName is the full name including the OICT-AUTO prefix
+func renamevCenterVM(ctx context.Context, vmID, vmName string) error {
+	defer timeTrack(time.Now(), "renamevCenterVM")
 
-	req.Header.Add("vmware-api-session-id", session)
+	_, err := runVimTask(ctx, "/VirtualMachine/"+vmID+"/Rename_Task", map[string]string{
+		"newName": vmName,
+	})
 
-	// Send the request to delete the VM
-	resp, err := client.Do(req)
+	return err
+}
+
+func deletevCenterVM(ctx context.Context, vmID string) error {
+	defer timeTrack(time.Now(), "deletevCenterVM")
+
+	err := forcePowerOff(ctx, vmID)
 	if err != nil {
-		log.Println("Error sending request: ", err)
+		return err
 	}
 
-	if resp.StatusCode != 204 {
-		return false
-	}
+	return vCenter.Do(ctx, "DELETE", "/api/vcenter/vm/"+vmID, nil, nil)
+}
+
+// the start script writes its output here in the guest, so it can be read back when the script is done
+const startScriptOutputPath = "/tmp/oict-start-script.log"
 
-	return true
+// startScriptResult is how the start script ended, Output is everything the script wrote to stdout and stderr
+type startScriptResult struct {
+	ExitCode int
+	Output   string
 }
 
-func runStartScript(session string, startScript startScript, firstName, studentId, vCenterId, ip, vmName string) error {
-	type Credentials struct {
-		InteractiveSession bool   `json:"interactive_session"`
-		Type               string `json:"type"`
-		UserName           string `json:"user_name"`
-		Password           string `json:"password"`
-	}
+type vCenterGuestCredentials struct {
+	InteractiveSession bool   `json:"interactive_session"`
+	Type               string `json:"type"`
+	UserName           string `json:"user_name"`
+	Password           string `json:"password"`
+}
+
+// runStartScript starts the start script in the guest and waits until it exits, VMware Tools has to be running,
+// see waitForGuestTools. The arguments the script gets and what it has to do with them are in startScripts/README.md
+func runStartScript(ctx context.Context, startScript startScript, firstName, password, studentId, vCenterId, ip, ipv6, vmName string, sshKeys []string) (startScriptResult, error) {
+	defer timeTrack(time.Now(), "runStartScript")
 
 	type Spec struct {
 		Arguments string `json:"arguments"`
 		Path      string `json:"path"`
 	}
 
-	type StartScript struct {
-		Credentials Credentials `json:"credentials"`
-		Spec        Spec        `json:"spec"`
+	credentials := vCenterGuestCredentials{
+		InteractiveSession: false,
+		Type:               "USERNAME_PASSWORD",
+		UserName:           startScript.User,
+		Password:           startScript.Password,
 	}
 
-	client := createVCenterHTTPClient()
+	// base64 so the keys are a single argument that the shell leaves alone
+	authorizedKeys := ""
+	if len(sshKeys) > 0 {
+		authorizedKeys = base64.StdEncoding.EncodeToString([]byte(strings.Join(sshKeys, "\n") + "\n"))
+	}
 
-	baseURL := getEnvVar("VCENTER_URL")
+	// VMware Tools runs the arguments through the shell of the guest, so the output can be redirected to a file.
+	// scriptLocation comes from the template and is shell itself (it pipes the password to sudo), every argument
+	// after it is quoted so nothing in them is run by that shell and empty ones keep their place
+	arguments := []string{studentId, firstName, ip, password, vmName, ipv6, authorizedKeys, startScriptExpirePassword}
+	for i, argument := range arguments {
+		arguments[i] = shellQuote(argument)
+	}
 
-	reqBodyPre := StartScript{
-		Credentials: Credentials{
-			InteractiveSession: false,
-			Type:               "USERNAME_PASSWORD",
-			UserName:           startScript.User,
-			Password:           startScript.Password,
-		},
-		Spec: Spec{
-			Arguments: startScript.ScriptLocation + " " + studentId + " " + firstName + " " + ip + " " + firstName + " " + " " + vmName,
+	reqBody := map[string]interface{}{
+		"credentials": credentials,
+		"spec": Spec{
+			Arguments: startScript.ScriptLocation + " " + strings.Join(arguments, " ") + " > " + startScriptOutputPath + " 2>&1",
 			Path:      startScript.ScriptExecutable,
 		},
 	}
 
-	jsonBody, err := json.Marshal(reqBodyPre)
-	if err != nil {
-		log.Println("Error marshalling start script: ", err)
-	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(getIntEnvVar("START_SCRIPT_TIMEOUT_SECONDS", 1800))*time.Second)
+	defer cancel()
 
-	req, err := http.NewRequest("POST", baseURL+"/api/vcenter/vm/"+vCenterId+"/guest/processes?action=create", bytes.NewBuffer(jsonBody))
+	var pid int64
+	err := vCenter.Do(ctx, "POST", "/api/vcenter/vm/"+vCenterId+"/guest/processes?action=create", reqBody, &pid)
 	if err != nil {
-		log.Println("Error creating request: ", err)
+		return startScriptResult{}, err
 	}
 
-	req.Header.Add("vmware-api-session-id", session)
-	req.Header.Add("Content-Type", "application/json")
+	pidString := strconv.FormatInt(pid, 10)
+	for {
+		var process struct {
+			Finished string `json:"finished"`
+			ExitCode *int   `json:"exit_code"`
+		}
+		err = vCenter.Do(ctx, "POST", "/api/vcenter/vm/"+vCenterId+"/guest/processes/"+pidString+"?action=get", map[string]interface{}{
+			"credentials": credentials,
+		}, &process)
+		if err != nil {
+			return startScriptResult{}, err
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-	}
+		if process.Finished != "" && process.ExitCode != nil {
+			result := startScriptResult{ExitCode: *process.ExitCode}
 
-	if resp.StatusCode != 201 {
-		// print the response body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Println("Error reading response body: ", err)
+			// the output is only to find out what went wrong, the exit code decides if the script worked
+			result.Output, err = readGuestFile(ctx, vCenterId, credentials, startScriptOutputPath)
+			if err != nil {
+				log.Println("Error reading output of start script: ", err)
+			}
+
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return startScriptResult{}, fmt.Errorf("start script in VM %s did not finish: %w", vCenterId, ctx.Err())
+		case <-time.After(vCenterGuestPollInterval):
 		}
+	}
+}
 
-		log.Println(string(body))
+// the last argument of every start script, it tells the script to make the initial password expire with chage -d 0
+// so the owner has to change it on the first login
+const startScriptExpirePassword = "expire-password"
+
+// shellQuote puts the argument between single quotes for a POSIX shell, a single quote in it ends the quoted part,
+// is added escaped and starts a new quoted part
+func shellQuote(argument string) string {
+	return "'" + strings.ReplaceAll(argument, "'", `'\''`) + "'"
+}
 
-		return errors.New("Error starting script status:" + string(rune(resp.StatusCode)) + " vCenter body: \n" + string(body))
+// readGuestFile downloads a file from the guest, vCenter hands out a URL on the host the VM runs on for this
+func readGuestFile(ctx context.Context, vCenterId string, credentials vCenterGuestCredentials, path string) (string, error) {
+	var transferURL string
+	err := vCenter.Do(ctx, "POST", "/api/vcenter/vm/"+vCenterId+"/guest/filesystem?action=create", map[string]interface{}{
+		"credentials": credentials,
+		"spec": map[string]string{
+			"path": path,
+		},
+	}, &transferURL)
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	content, err := vCenter.Download(ctx, transferURL)
+
+	return string(content), err
 }
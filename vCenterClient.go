@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vCenterAPI is everything the API needs from vCenter, the vCenter functions only talk to vCenter through it
+// so tests can replace it with fakeVCenter (vCenterClient_test.go)
+type vCenterAPI interface {
+	// Do calls the REST API, path starts with /api
+	Do(ctx context.Context, method, path string, body, result interface{}) error
+	// DoVim calls the VI/JSON API, path is relative to /sdk/vim25/{release}, for the things the REST API can't do
+	// (snapshots, tickets, renaming)
+	DoVim(ctx context.Context, method, path string, body, result interface{}) error
+	// Download fetches a URL vCenter handed out, like the transfer URL of a file in the guest, those URLs are
+	// already authorized so no session is sent
+	Download(ctx context.Context, url string) ([]byte, error)
+}
+
+// VCenterError is returned for every response of vCenter that isn't a 2xx
+type VCenterError struct {
+	StatusCode int
+	// the error_type of the REST API (NOT_FOUND, ALREADY_EXISTS, ...) or the fault type of the VI/JSON API
+	ErrorType string
+	Message   string
+	Method    string
+	Path      string
+}
+
+func (e *VCenterError) Error() string {
+	return fmt.Sprintf("vCenter %s %s status: %d %s: %s", e.Method, e.Path, e.StatusCode, e.ErrorType, e.Message)
+}
+
+// isVCenterError checks if vCenter returned the given error_type, like NOT_FOUND or ALREADY_EXISTS
+func isVCenterError(err error, errorType string) bool {
+	var vCenterErr *VCenterError
+	return errors.As(err, &vCenterErr) && vCenterErr.ErrorType == errorType
+}
+
+type vCenterClient struct {
+	baseURL    string
+	vimRelease string
+	user       string
+	pass       string
+	timeout    time.Duration
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	session string
+}
+
+// vCenter is the client all vCenter functions use, it shares one connection pool and one session
+var vCenter vCenterAPI = newVCenterClient()
+
+func newVCenterClient() *vCenterClient {
+	vimRelease := getEnvVar("VCENTER_VIM_RELEASE")
+	if vimRelease == "" {
+		vimRelease = "8.0.1.0"
+	}
+
+	return &vCenterClient{
+		baseURL:    getEnvVar("VCENTER_URL"),
+		vimRelease: vimRelease,
+		user:       getEnvVar("VCENTER_USER"),
+		pass:       getEnvVar("VCENTER_PASS"),
+		timeout:    time.Duration(getIntEnvVar("VCENTER_REQUEST_TIMEOUT_SECONDS", 60)) * time.Second,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				// skip SSL verification if needed because the vCenter certificate is self-signed
+				TLSClientConfig:     &tls.Config{InsecureSkipVerify: !getBoolEnvVar("VERIFY_TLS")},
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+// withVCenterTaskTimeout is for the calls that wait on vCenter doing the work, like deploying a VM or a task of the
+// VI/JSON API, those take longer than the timeout of a normal request
+func withVCenterTaskTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(getIntEnvVar("VCENTER_TASK_TIMEOUT_SECONDS", 1800))*time.Second)
+}
+
+func (c *vCenterClient) Do(ctx context.Context, method, path string, body, result interface{}) error {
+	return c.do(ctx, method, c.baseURL+path, path, body, result)
+}
+
+func (c *vCenterClient) DoVim(ctx context.Context, method, path string, body, result interface{}) error {
+	return c.do(ctx, method, c.baseURL+"/sdk/vim25/"+c.vimRelease+path, path, body, result)
+}
+
+func (c *vCenterClient) Download(ctx context.Context, url string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &VCenterError{StatusCode: resp.StatusCode, Message: string(body), Method: "GET", Path: req.URL.Path}
+	}
+
+	return body, nil
+}
+
+// do sends the request with the current session, when vCenter says the session has expired it logs in again
+// and retries the request once
+func (c *vCenterClient) do(ctx context.Context, method, url, path string, body, result interface{}) error {
+	var jsonBody []byte
+	if body != nil {
+		var err error
+		jsonBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	// calls without a deadline of their own get the default timeout
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	session, err := c.getSession(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	respBody, err := c.send(ctx, method, url, path, session, jsonBody)
+	if isVCenterError(err, "UNAUTHENTICATED") || isVCenterError(err, "NotAuthenticated") {
+		session, err = c.getSession(ctx, session)
+		if err != nil {
+			return err
+		}
+		respBody, err = c.send(ctx, method, url, path, session, jsonBody)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result == nil || len(respBody) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(respBody, result)
+}
+
+func (c *vCenterClient) send(ctx context.Context, method, url, path, session string, jsonBody []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("vmware-api-session-id", session)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, parseVCenterError(resp.StatusCode, method, path, respBody)
+	}
+
+	return respBody, nil
+}
+
+// parseVCenterError reads the error of the REST API ({"error_type": ..., "messages": [...]})
+// or of the VI/JSON API ({"_typeName": ..., "faultMessage": [...]})
+func parseVCenterError(statusCode int, method, path string, body []byte) *VCenterError {
+	var vCenterBody struct {
+		ErrorType string `json:"error_type"`
+		Messages  []struct {
+			DefaultMessage string `json:"default_message"`
+		} `json:"messages"`
+		TypeName     string `json:"_typeName"`
+		FaultMessage []struct {
+			Message string `json:"message"`
+		} `json:"faultMessage"`
+	}
+	_ = json.Unmarshal(body, &vCenterBody)
+
+	vCenterErr := &VCenterError{StatusCode: statusCode, ErrorType: vCenterBody.ErrorType, Method: method, Path: path}
+
+	var messages []string
+	for _, message := range vCenterBody.Messages {
+		messages = append(messages, message.DefaultMessage)
+	}
+	if vCenterErr.ErrorType == "" {
+		vCenterErr.ErrorType = vCenterBody.TypeName
+		for _, message := range vCenterBody.FaultMessage {
+			messages = append(messages, message.Message)
+		}
+	}
+	if vCenterErr.ErrorType == "" && statusCode == 401 {
+		vCenterErr.ErrorType = "UNAUTHENTICATED"
+	}
+
+	vCenterErr.Message = strings.Join(messages, ", ")
+	if vCenterErr.Message == "" {
+		vCenterErr.Message = string(body)
+	}
+
+	return vCenterErr
+}
+
+// getSession returns the session of the client, logging in when there is none yet or when the session is the
+// expired one, so requests that fail at the same time only log in once
+func (c *vCenterClient) getSession(ctx context.Context, expired string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != "" && c.session != expired {
+		return c.session, nil
+	}
+
+	log.Println("Logging in to vCenter")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/session", nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(c.user, c.pass)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != 201 && resp.StatusCode != 200 {
+		return "", parseVCenterError(resp.StatusCode, "POST", "/api/session", body)
+	}
+
+	var session string
+	err = json.Unmarshal(body, &session)
+	if err != nil {
+		return "", err
+	}
+
+	c.session = session
+
+	return session, nil
+}
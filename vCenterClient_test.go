@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// fakeVCenter answers the calls of the vCenter functions without a vCenter, the responses are by "METHOD path"
+// (for Download by "GET url"). An error is returned as is, anything else is put in the result like vCenter
+// would answer with it as JSON. Calls without a response get NOT_FOUND
+type fakeVCenter struct {
+	responses map[string]interface{}
+	calls     []string
+}
+
+// useFakeVCenter replaces the vCenter client until the test is done
+func useFakeVCenter(t *testing.T, responses map[string]interface{}) *fakeVCenter {
+	fake := &fakeVCenter{responses: responses}
+
+	previous := vCenter
+	vCenter = fake
+	t.Cleanup(func() { vCenter = previous })
+
+	return fake
+}
+
+func (f *fakeVCenter) respond(method, path string, result interface{}) error {
+	key := method + " " + path
+	f.calls = append(f.calls, key)
+
+	response, ok := f.responses[key]
+	if !ok {
+		return &VCenterError{StatusCode: 404, ErrorType: "NOT_FOUND", Method: method, Path: path}
+	}
+	if err, ok := response.(error); ok {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+func (f *fakeVCenter) Do(ctx context.Context, method, path string, body, result interface{}) error {
+	return f.respond(method, path, result)
+}
+
+func (f *fakeVCenter) DoVim(ctx context.Context, method, path string, body, result interface{}) error {
+	return f.respond(method, path, result)
+}
+
+func (f *fakeVCenter) Download(ctx context.Context, url string) ([]byte, error) {
+	var content string
+	err := f.respond("GET", url, &content)
+
+	return []byte(content), err
+}
+
+func TestParseVCenterError(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		wantType    string
+		wantMessage string
+	}{
+		{
+			name:        "REST API error",
+			statusCode:  404,
+			body:        `{"error_type": "NOT_FOUND", "messages": [{"default_message": "VM not found"}, {"default_message": "vm-1"}]}`,
+			wantType:    "NOT_FOUND",
+			wantMessage: "VM not found, vm-1",
+		},
+		{
+			name:        "VI/JSON API fault",
+			statusCode:  500,
+			body:        `{"_typeName": "InvalidPowerState", "faultMessage": [{"message": "The VM is powered on"}]}`,
+			wantType:    "InvalidPowerState",
+			wantMessage: "The VM is powered on",
+		},
+		{
+			name:        "expired session without a body",
+			statusCode:  401,
+			body:        ``,
+			wantType:    "UNAUTHENTICATED",
+			wantMessage: "",
+		},
+		{
+			name:        "body that isn't JSON",
+			statusCode:  502,
+			body:        `Bad Gateway`,
+			wantType:    "",
+			wantMessage: "Bad Gateway",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := parseVCenterError(test.statusCode, "GET", "/api/vcenter/vm/vm-1", []byte(test.body))
+
+			if err.StatusCode != test.statusCode || err.ErrorType != test.wantType || err.Message != test.wantMessage {
+				t.Errorf("got %d %q %q, want %d %q %q", err.StatusCode, err.ErrorType, err.Message, test.statusCode, test.wantType, test.wantMessage)
+			}
+			if test.wantType != "" && !isVCenterError(err, test.wantType) {
+				t.Errorf("isVCenterError(%q) is false", test.wantType)
+			}
+		})
+	}
+}
+
+func TestVCenterVMExists(t *testing.T) {
+	tests := []struct {
+		name     string
+		response interface{}
+		want     bool
+	}{
+		{"VM exists", map[string]string{"name": "OICT-AUTO-123-web"}, true},
+		{"VM is gone", nil, false},
+		// only NOT_FOUND means the VM is gone, a failing vCenter must not make the caller delete anything
+		{"vCenter fails", &VCenterError{StatusCode: 503, ErrorType: "SERVICE_UNAVAILABLE"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responses := map[string]interface{}{}
+			if test.response != nil {
+				responses["GET /api/vcenter/vm/vm-1"] = test.response
+			}
+			useFakeVCenter(t, responses)
+
+			if got := vCenterVMExists(context.Background(), "vm-1"); got != test.want {
+				t.Errorf("vCenterVMExists() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetvCenterPowerState(t *testing.T) {
+	fake := useFakeVCenter(t, map[string]interface{}{
+		"GET /api/vcenter/vm/vm-1/power": map[string]string{"state": "POWERED_OFF"},
+	})
+
+	state, err := getvCenterPowerState(context.Background(), "vm-1")
+	if err != nil || state != "POWERED_OFF" {
+		t.Errorf("getvCenterPowerState() = %q, %v, want POWERED_OFF", state, err)
+	}
+	if len(fake.calls) != 1 {
+		t.Errorf("got %d calls to vCenter, want 1", len(fake.calls))
+	}
+}
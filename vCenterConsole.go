@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errVMNotPoweredOn = errors.New("VM is not powered on")
+
+type vCenterConsoleTicket struct {
+	Ticket string `json:"ticket"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	// the websocket the WebMKS client in the frontend connects to
+	Url           string `json:"url"`
+	SslThumbprint string `json:"sslThumbprint"`
+}
+
+// acquirevCenterConsoleTicket asks vCenter for a WebMKS ticket, the ticket can be used once and expires after a short time
+func acquirevCenterConsoleTicket(ctx context.Context, vmID string) (vCenterConsoleTicket, error) {
+	defer timeTrack(time.Now(), "acquirevCenterConsoleTicket")
+
+	var runtime struct {
+		PowerState string `json:"powerState"`
+	}
+	err := vCenter.DoVim(ctx, "GET", "/VirtualMachine/"+vmID+"/runtime", nil, &runtime)
+	if err != nil {
+		return vCenterConsoleTicket{}, err
+	}
+
+	// vCenter only hands out tickets for VMs that are running
+	if runtime.PowerState != "poweredOn" {
+		return vCenterConsoleTicket{}, errVMNotPoweredOn
+	}
+
+	var ticket vCenterConsoleTicket
+	err = vCenter.DoVim(ctx, "POST", "/VirtualMachine/"+vmID+"/AcquireTicket", map[string]string{
+		"ticketType": "webmks",
+	}, &ticket)
+	if err != nil {
+		return vCenterConsoleTicket{}, err
+	}
+
+	// older ESXi hosts don't fill in the url
+	if ticket.Url == "" {
+		ticket.Url = fmt.Sprintf("wss://%s:%d/ticket/%s", ticket.Host, ticket.Port, ticket.Ticket)
+	}
+
+	return ticket, nil
+}
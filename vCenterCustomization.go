@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templateCustomization is the "customization" of a template in startScripts/{template}.json, when a template has one
+// the guest is configured by vCenter on its first boot and the start script is only run when the template still has one
+type templateCustomization struct {
+	// cloud-init for templates with cloud-init installed, linux for the guest customization of vCenter itself
+	// (which can't make users, so those templates still need a start script for that)
+	Type string `json:"type"`
+	// the account that is made for the student, the student ID when empty (cloud-init only)
+	User string `json:"user"`
+	// SSH keys that are added to the account (cloud-init only)
+	SshKeys []string `json:"sshKeys"`
+	// extra cloud-config that is added to the user-data, like packages or runcmd (cloud-init only)
+	CloudConfig map[string]interface{} `json:"cloudConfig"`
+	Domain      string                 `json:"domain"`
+	TimeZone    string                 `json:"timeZone"`
+}
+
+// vCenterGuestCustomization is everything of one VM the guest is configured with
+type vCenterGuestCustomization struct {
+	Template templateCustomization
+	HostName string
+	UserName string
+	// the SSH keys of the owner, password login over SSH is turned off when there are any
+	UserSshKeys []string
+	// the initial password, it has to be changed on the first login (cloud-init only)
+	Password   string
+	IP         string
+	IPv6       string
+	Gateway    string
+	GatewayV6  string
+	DnsServers []string
+}
+
+var invalidHostNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// guestHostName turns the name of the server into a valid hostname
+func guestHostName(serverName string) string {
+	hostName := invalidHostNameChars.ReplaceAllString(strings.ToLower(serverName), "-")
+	if len(hostName) > 63 {
+		hostName = hostName[:63]
+	}
+
+	hostName = strings.Trim(hostName, "-")
+	if hostName == "" {
+		return "server"
+	}
+
+	return hostName
+}
+
+func guestUserName(template templateCustomization, studentID string) string {
+	if template.User != "" {
+		return template.User
+	}
+
+	return strings.ToLower(studentID)
+}
+
+// getGuestCustomization returns the customization for a new VM of the template, or nil when the template
+// only uses the start script
+func getGuestCustomization(templateName, serverName, studentID, ip, ipv6 string, network Network, sshKeys []string, password string) *vCenterGuestCustomization {
+	script, err := readStartScript(templateName)
+	if err != nil || script.Customization == nil {
+		return nil
+	}
+
+	customization := &vCenterGuestCustomization{
+		Template:    *script.Customization,
+		HostName:    guestHostName(serverName),
+		UserName:    guestUserName(*script.Customization, studentID),
+		UserSshKeys: sshKeys,
+		Password:    password,
+		IP:          ip,
+		IPv6:        ipv6,
+	}
+
+	// without a gateway the network can't be set up statically, the guest then uses DHCP
+	if network.Gateway != "" {
+		customization.Gateway = network.Gateway
+		customization.GatewayV6 = network.GatewayIPv6
+		for _, dnsServer := range strings.Split(network.DnsServers, ",") {
+			if strings.TrimSpace(dnsServer) != "" {
+				customization.DnsServers = append(customization.DnsServers, strings.TrimSpace(dnsServer))
+			}
+		}
+	}
+
+	return customization
+}
+
+// setvCenterGuestCustomization gives the customization to vCenter, the VM has to be powered off and the guest is
+// configured on the next power on
+func setvCenterGuestCustomization(ctx context.Context, vmID string, customization *vCenterGuestCustomization) error {
+	defer timeTrack(time.Now(), "setvCenterGuestCustomization")
+
+	spec, err := customization.spec()
+	if err != nil {
+		return err
+	}
+
+	return vCenter.Do(ctx, "PUT", "/api/vcenter/vm/"+vmID+"/guest/customization", map[string]interface{}{
+		"spec": spec,
+	}, nil)
+}
+
+// spec builds the CustomizationSpec of the REST API
+func (customization *vCenterGuestCustomization) spec() (map[string]interface{}, error) {
+	if customization.Template.Type == "cloud-init" {
+		metadata, err := customization.cloudInitMetadata()
+		if err != nil {
+			return nil, err
+		}
+
+		userdata, err := customization.cloudInitUserData()
+		if err != nil {
+			return nil, err
+		}
+
+		// cloud-init configures the network itself from the metadata
+		return map[string]interface{}{
+			"configuration_spec": map[string]interface{}{
+				"cloud_config": map[string]interface{}{
+					"type": "CLOUDINIT",
+					"cloudinit": map[string]string{
+						"metadata": metadata,
+						"userdata": userdata,
+					},
+				},
+			},
+			"global_DNS_settings": map[string]interface{}{},
+			"interfaces":          []interface{}{},
+		}, nil
+	}
+
+	linuxConfig := map[string]interface{}{
+		"hostname": map[string]string{
+			"type":       "FIXED",
+			"fixed_name": customization.HostName,
+		},
+		"domain": customization.Template.Domain,
+	}
+	if customization.Template.TimeZone != "" {
+		linuxConfig["time_zone"] = customization.Template.TimeZone
+	}
+
+	ipv4 := map[string]interface{}{"type": "DHCP"}
+	if gateway, err := netip.ParsePrefix(customization.Gateway); err == nil {
+		ipv4 = map[string]interface{}{
+			"type":       "STATIC",
+			"ip_address": customization.IP,
+			"prefix":     gateway.Bits(),
+			"gateways":   []string{gateway.Addr().String()},
+		}
+	}
+	adapter := map[string]interface{}{"ipv4": ipv4}
+	// IPv6 is left alone for servers without an IPv6 address
+	if gateway, err := netip.ParsePrefix(customization.GatewayV6); err == nil && customization.IPv6 != "" {
+		adapter["ipv6"] = map[string]interface{}{
+			"type":     "STATIC",
+			"ipv6":     []map[string]interface{}{{"ip_address": customization.IPv6, "prefix": gateway.Bits()}},
+			"gateways": []string{gateway.Addr().String()},
+		}
+	}
+
+	return map[string]interface{}{
+		"configuration_spec": map[string]interface{}{
+			"linux_config": linuxConfig,
+		},
+		"global_DNS_settings": map[string]interface{}{
+			"dns_servers": customization.DnsServers,
+		},
+		"interfaces": []interface{}{
+			map[string]interface{}{
+				"adapter": adapter,
+			},
+		},
+	}, nil
+}
+
+// cloudInitMetadata is the metadata of the NoCloud datasource, the network config uses netplan version 2.
+// cloud-init accepts JSON because it is valid YAML
+func (customization *vCenterGuestCustomization) cloudInitMetadata() (string, error) {
+	metadata := map[string]interface{}{
+		// a new instance-id makes cloud-init run again, so a clone doesn't keep the config of its source
+		"instance-id":    customization.HostName + "-" + customization.IP,
+		"local-hostname": customization.HostName,
+	}
+
+	ethernet := map[string]interface{}{
+		"match": map[string]string{"name": "e*"},
+		"dhcp4": true,
+	}
+	if gateway, err := netip.ParsePrefix(customization.Gateway); err == nil {
+		addresses := []string{customization.IP + "/" + strconv.Itoa(gateway.Bits())}
+		routes := []map[string]string{{"to": "default", "via": gateway.Addr().String()}}
+
+		if gatewayV6, err := netip.ParsePrefix(customization.GatewayV6); err == nil && customization.IPv6 != "" {
+			addresses = append(addresses, customization.IPv6+"/"+strconv.Itoa(gatewayV6.Bits()))
+			routes = append(routes, map[string]string{"to": "::/0", "via": gatewayV6.Addr().String()})
+		}
+
+		ethernet = map[string]interface{}{
+			"match":       map[string]string{"name": "e*"},
+			"addresses":   addresses,
+			"routes":      routes,
+			"nameservers": map[string]interface{}{"addresses": customization.DnsServers},
+		}
+	}
+
+	metadata["network"] = map[string]interface{}{
+		"version":   2,
+		"ethernets": map[string]interface{}{"primary": ethernet},
+	}
+
+	metadataJson, err := json.Marshal(metadata)
+	return string(metadataJson), err
+}
+
+func (customization *vCenterGuestCustomization) cloudInitUserData() (string, error) {
+	userData := map[string]interface{}{}
+	for key, value := range customization.Template.CloudConfig {
+		userData[key] = value
+	}
+
+	sshKeys := append([]string{}, customization.Template.SshKeys...)
+	sshKeys = append(sshKeys, customization.UserSshKeys...)
+
+	user := map[string]interface{}{
+		"name":                customization.UserName,
+		"groups":              "sudo",
+		"shell":               "/bin/bash",
+		"sudo":                "ALL=(ALL) NOPASSWD:ALL",
+		"ssh_authorized_keys": sshKeys,
+	}
+
+	// the password still works on the console, but students with an SSH key can only use that key over SSH
+	user["lock_passwd"] = false
+	userData["ssh_pwauth"] = len(customization.UserSshKeys) == 0
+	userData["chpasswd"] = map[string]interface{}{
+		"expire": true,
+		"users": []map[string]string{
+			{"name": customization.UserName, "password": customization.Password, "type": "text"},
+		},
+	}
+
+	userData["hostname"] = customization.HostName
+	userData["users"] = []interface{}{"default", user}
+	if customization.Template.TimeZone != "" {
+		userData["timezone"] = customization.Template.TimeZone
+	}
+
+	userDataJson, err := json.Marshal(userData)
+	if err != nil {
+		return "", err
+	}
+
+	return "#cloud-config\n" + string(userDataJson), nil
+}
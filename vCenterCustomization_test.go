@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuestHostName(t *testing.T) {
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"web-01", "web-01"},
+		{"WebServer", "webserver"},
+		{"my_server.test", "my-server-test"},
+		{"--web--", "web"},
+		{"héllo wörld", "h-llo-w-rld"},
+		{"___", "server"},
+		{"", "server"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+		// the dash at position 63 is trimmed after cutting
+		{strings.Repeat("a", 62) + "_b", strings.Repeat("a", 62)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.serverName, func(t *testing.T) {
+			if got := guestHostName(test.serverName); got != test.want {
+				t.Errorf("guestHostName(%q) = %q, want %q", test.serverName, got, test.want)
+			}
+		})
+	}
+}
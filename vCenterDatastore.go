@@ -1,19 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/labstack/echo/v4"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
 
-func getvCenterDataStoreID(session string) string {
+func getvCenterDataStoreID(ctx context.Context) (string, error) {
 	if existsInRedis("data_store_id_last_updated") == false {
-		return updateDataStoreID(session)
+		return updateDataStoreID(ctx)
 	}
 
 	// check if the data store ID was updated today, otherwise update it
@@ -22,13 +22,13 @@ func getvCenterDataStoreID(session string) string {
 		dataStoreIDLastUpdated = "0"
 	}
 	if time.Now().Unix()-stringToInt64(dataStoreIDLastUpdated) > 86400 {
-		return updateDataStoreID(session)
+		return updateDataStoreID(ctx)
 	}
 
-	return getFromRedis("data_store_id")
+	return getFromRedis("data_store_id"), nil
 }
 
-func updateDataStoreID(session string) string {
+func updateDataStoreID(ctx context.Context) (string, error) {
 	type DataStore struct {
 		DataStore string `json:"datastore"`
 		Name      string `json:"name"`
@@ -37,57 +37,28 @@ func updateDataStoreID(session string) string {
 		Capacity  int64  `json:"capacity"`
 	}
 
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
-
-	req, err := http.NewRequest("GET", baseURL+"/api/vcenter/datastore?names="+getEnvVar("VCENTER_DATASTORE_NAME"), nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-		return ""
-	}
-
-	req.Header.Add("vmware-api-session-id", session)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-		return ""
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Error reading response: ", err)
-		return ""
-	}
-
-	fmt.Println("Response: ", string(body))
-
-	if resp.StatusCode != 200 {
-		log.Println("Error getting data store ID: ", resp)
-		return ""
-	}
-
 	var dataStores []DataStore
-	err = json.Unmarshal(body, &dataStores)
+	err := vCenter.Do(ctx, "GET", "/api/vcenter/datastore?names="+url.QueryEscape(getEnvVar("VCENTER_DATASTORE_NAME")), nil, &dataStores)
 	if err != nil {
-		log.Println("Error unmarshalling response: ", err)
-		return ""
+		return "", err
 	}
 
 	if len(dataStores) == 0 {
-		log.Println("No data stores found in response: ", string(body))
-		return ""
+		return "", fmt.Errorf("no data store found with the name %s", getEnvVar("VCENTER_DATASTORE_NAME"))
 	}
 
 	setToRedis("data_store_id", dataStores[0].DataStore, 0)
 	setToRedis("data_store_id_last_updated", strconv.FormatInt(time.Now().Unix(), 10), 0)
 
-	return dataStores[0].DataStore
+	return dataStores[0].DataStore, nil
 }
 
 func RefreshDataStores(c echo.Context) error {
-	session := getVCenterSession()
-	dataStores := updateDataStoreID(session)
-	return c.JSON(http.StatusOK, dataStores)
+	dataStore, err := updateDataStoreID(c.Request().Context())
+	if err != nil {
+		log.Println("Error getting data store ID: ", err)
+		return c.JSON(http.StatusInternalServerError, "Error getting data store from vCenter")
+	}
+
+	return c.JSON(http.StatusOK, dataStore)
 }
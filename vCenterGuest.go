@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type vCenterGuestDisk struct {
+	Path string
+	// in bytes
+	Capacity  int64
+	FreeSpace int64
+}
+
+type vCenterGuestNic struct {
+	MacAddress  string
+	IPAddresses []string
+}
+
+// vCenterGuestDetails is what vCenter and VMware Tools know about the running VM, the guest fields stay empty
+// when VMware Tools isn't running
+type vCenterGuestDetails struct {
+	// already in PowerStatusReturn, encoding/json would drop both fields when they collide
+	PowerStatus    string `json:"-"`
+	GuestOS        string
+	HostName       string
+	Nics           []vCenterGuestNic
+	UptimeSeconds  int64
+	CpuUsageMHz    int
+	MaxCpuMHz      int
+	MemoryUsageMiB int
+	Disks          []vCenterGuestDisk
+	ToolsStatus    string
+	ToolsVersion   string
+}
+
+// how often the state of VMware Tools and of processes in the guest is checked
+const vCenterGuestPollInterval = 5 * time.Second
+
+// waitForGuestTools waits until VMware Tools is running in the VM, the guest can only run processes from then on.
+// How long a VM may take to boot is set with GUEST_TOOLS_TIMEOUT_SECONDS
+func waitForGuestTools(ctx context.Context, vmID string) error {
+	defer timeTrack(time.Now(), "waitForGuestTools")
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(getIntEnvVar("GUEST_TOOLS_TIMEOUT_SECONDS", 600))*time.Second)
+	defer cancel()
+
+	for {
+		var tools struct {
+			RunState string `json:"run_state"`
+		}
+		err := vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+vmID+"/tools", nil, &tools)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		if tools.RunState == "RUNNING" {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("VMware Tools in VM %s did not start: %w", vmID, ctx.Err())
+		case <-time.After(vCenterGuestPollInterval):
+		}
+	}
+}
+
+// the VI/JSON API names the power states differently than the REST API that GetServers uses
+var vimPowerStates = map[string]string{
+	"poweredOn":  "POWERED_ON",
+	"poweredOff": "POWERED_OFF",
+	"suspended":  "SUSPENDED",
+}
+
+func getvCenterGuestDetails(ctx context.Context, vmID string) (vCenterGuestDetails, error) {
+	defer timeTrack(time.Now(), "getvCenterGuestDetails")
+
+	var summary struct {
+		Runtime struct {
+			PowerState  string `json:"powerState"`
+			MaxCpuUsage int    `json:"maxCpuUsage"`
+		} `json:"runtime"`
+		QuickStats struct {
+			OverallCpuUsage  int   `json:"overallCpuUsage"`
+			GuestMemoryUsage int   `json:"guestMemoryUsage"`
+			UptimeSeconds    int64 `json:"uptimeSeconds"`
+		} `json:"quickStats"`
+	}
+	err := vCenter.DoVim(ctx, "GET", "/VirtualMachine/"+vmID+"/summary", nil, &summary)
+	if err != nil {
+		return vCenterGuestDetails{}, err
+	}
+
+	details := vCenterGuestDetails{
+		PowerStatus:    vimPowerStates[summary.Runtime.PowerState],
+		UptimeSeconds:  summary.QuickStats.UptimeSeconds,
+		CpuUsageMHz:    summary.QuickStats.OverallCpuUsage,
+		MaxCpuMHz:      summary.Runtime.MaxCpuUsage,
+		MemoryUsageMiB: summary.QuickStats.GuestMemoryUsage,
+		Nics:           []vCenterGuestNic{},
+		Disks:          []vCenterGuestDisk{},
+	}
+
+	var tools struct {
+		RunState      string `json:"run_state"`
+		VersionStatus string `json:"version_status"`
+		Version       string `json:"version"`
+	}
+	if vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+vmID+"/tools", nil, &tools) == nil {
+		details.ToolsStatus = tools.RunState
+		details.ToolsVersion = tools.Version
+	}
+
+	// the guest endpoints return an error as long as VMware Tools isn't running in the VM
+	if details.ToolsStatus != "RUNNING" {
+		return details, nil
+	}
+
+	var identity struct {
+		FullName struct {
+			DefaultMessage string `json:"default_message"`
+		} `json:"full_name"`
+		HostName string `json:"host_name"`
+	}
+	if vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+vmID+"/guest/identity", nil, &identity) == nil {
+		details.GuestOS = identity.FullName.DefaultMessage
+		details.HostName = identity.HostName
+	}
+
+	var interfaces []struct {
+		MacAddress string `json:"mac_address"`
+		IP         struct {
+			IPAddresses []struct {
+				IPAddress string `json:"ip_address"`
+			} `json:"ip_addresses"`
+		} `json:"ip"`
+	}
+	if vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+vmID+"/guest/networking/interfaces", nil, &interfaces) == nil {
+		for _, iface := range interfaces {
+			nic := vCenterGuestNic{MacAddress: iface.MacAddress, IPAddresses: []string{}}
+			for _, ip := range iface.IP.IPAddresses {
+				nic.IPAddresses = append(nic.IPAddresses, ip.IPAddress)
+			}
+			details.Nics = append(details.Nics, nic)
+		}
+	}
+
+	var filesystems map[string]struct {
+		Capacity  int64 `json:"capacity"`
+		FreeSpace int64 `json:"free_space"`
+	}
+	if vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+vmID+"/guest/local-filesystem", nil, &filesystems) == nil {
+		for path, filesystem := range filesystems {
+			details.Disks = append(details.Disks, vCenterGuestDisk{Path: path, Capacity: filesystem.Capacity, FreeSpace: filesystem.FreeSpace})
+		}
+		// vCenter returns a map, sort it so the order doesn't change on every request
+		sort.Slice(details.Disks, func(i, j int) bool {
+			return details.Disks[i].Path < details.Disks[j].Path
+		})
+	}
+
+	return details, nil
+}
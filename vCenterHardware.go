@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// the disk that gets resized when a VM is deployed from a template
+const vCenterRootDiskID = "2000"
+
+// the first network adapter of a VM
+const vCenterPrimaryNicID = "4000"
+
+// updatevCenterVMMemory sets the memory of the VM, memory is in GB
+func updatevCenterVMMemory(ctx context.Context, vmID string, memory int) error {
+	defer timeTrack(time.Now(), "updatevCenterVMMemory")
+
+	return vCenter.Do(ctx, "PATCH", "/api/vcenter/vm/"+vmID+"/hardware/memory", map[string]int{
+		"size_MiB": memory * 1024,
+	}, nil)
+}
+
+// updatevCenterVMDiskCapacity grows the disk of the VM, storage is in GB
+func updatevCenterVMDiskCapacity(ctx context.Context, vmID, diskID string, storage int) error {
+	defer timeTrack(time.Now(), "updatevCenterVMDiskCapacity")
+
+	return vCenter.Do(ctx, "PATCH", "/api/vcenter/vm/"+vmID+"/hardware/disk/"+diskID, map[string]int{
+		// storage is in GB, so we need to convert it to bytes
+		"capacity": storage * 1073741824,
+	}, nil)
+}
+
+// createvCenterVMDisk adds a new empty disk to the VM and returns the ID vCenter gave it, capacity is in GB
+func createvCenterVMDisk(ctx context.Context, vmID string, capacity int) (string, error) {
+	defer timeTrack(time.Now(), "createvCenterVMDisk")
+
+	var diskID string
+	err := vCenter.Do(ctx, "POST", "/api/vcenter/vm/"+vmID+"/hardware/disk", map[string]map[string]int{
+		"new_vmdk": {
+			// capacity is in GB, so we need to convert it to bytes
+			"capacity": capacity * 1073741824,
+		},
+	}, &diskID)
+
+	return diskID, err
+}
+
+// deletevCenterVMDisk removes the disk from the VM and destroys its VMDK, the DELETE of the REST API only detaches
+// the disk and leaves the file on the datastore. The disk ID of the REST API is the device key of the VI/JSON API
+func deletevCenterVMDisk(ctx context.Context, vmID, diskID string) error {
+	defer timeTrack(time.Now(), "deletevCenterVMDisk")
+
+	var config struct {
+		Hardware struct {
+			Device []json.RawMessage `json:"device"`
+		} `json:"hardware"`
+	}
+	err := vCenter.DoVim(ctx, "GET", "/VirtualMachine/"+vmID+"/config", nil, &config)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range config.Hardware.Device {
+		var key struct {
+			Key int `json:"key"`
+		}
+		if json.Unmarshal(device, &key) != nil || strconv.Itoa(key.Key) != diskID {
+			continue
+		}
+
+		_, err = runVimTask(ctx, "/VirtualMachine/"+vmID+"/ReconfigVM_Task", map[string]interface{}{
+			"spec": map[string]interface{}{
+				"_typeName": "VirtualMachineConfigSpec",
+				"deviceChange": []map[string]interface{}{{
+					"_typeName":     "VirtualDeviceConfigSpec",
+					"operation":     "remove",
+					"fileOperation": "destroy",
+					"device":        device,
+				}},
+			},
+		})
+		return err
+	}
+
+	return fmt.Errorf("disk %s not found on VM %s", diskID, vmID)
+}
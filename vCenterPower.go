@@ -1,118 +1,52 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"context"
 	"time"
 )
 
-func powerOn(session, id string) bool {
+func powerOn(ctx context.Context, id string) error {
 	defer timeTrack(time.Now(), "powerOn")
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
 
-	req, err := http.NewRequest("POST", baseURL+"/api/vcenter/vm/"+id+"/power?action=start", nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-		return false
-	}
-
-	req.Header.Add("vmware-api-session-id", session)
-
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-	}
-
-	if resp.StatusCode != 204 && resp.StatusCode != 400 {
-		return false
-	}
-
-	defer resp.Body.Close()
-
-	return true
+	return doVCenterPowerAction(ctx, id, "start")
 }
 
-func powerOff(session, id string) bool {
+// powerOff suspends the VM, forcePowerOff is the one that turns it off
+func powerOff(ctx context.Context, id string) error {
 	defer timeTrack(time.Now(), "powerOff")
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
-
-	req, err := http.NewRequest("POST", baseURL+"/api/vcenter/vm/"+id+"/power?action=suspend", nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-		return false
-	}
-
-	req.Header.Add("vmware-api-session-id", session)
-
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-		return false
-	}
-
-	if resp.StatusCode != 204 && resp.StatusCode != 400 {
-		return false
-	}
 
-	defer resp.Body.Close()
-
-	return true
+	return doVCenterPowerAction(ctx, id, "suspend")
 }
 
-func forcePowerOff(session, id string) bool {
+func forcePowerOff(ctx context.Context, id string) error {
 	defer timeTrack(time.Now(), "forcePowerOff")
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
-
-	req, err := http.NewRequest("POST", baseURL+"/api/vcenter/vm/"+id+"/power?action=stop", nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-	}
-
-	req.Header.Add("vmware-api-session-id", session)
-
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-	}
-
-	if resp.StatusCode != 204 && resp.StatusCode != 400 {
-		return false
-	}
-
-	defer resp.Body.Close()
 
-	return true
+	return doVCenterPowerAction(ctx, id, "stop")
 }
 
-func reset(session, id string) bool {
+func reset(ctx context.Context, id string) error {
 	defer timeTrack(time.Now(), "reset")
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
 
-	req, err := http.NewRequest("POST", baseURL+"/api/vcenter/vm/"+id+"/power?action=reset", nil)
-	if err != nil {
-		log.Println("Error creating request: ", err)
-	}
+	return doVCenterPowerAction(ctx, id, "reset")
+}
 
-	req.Header.Add("vmware-api-session-id", session)
+func doVCenterPowerAction(ctx context.Context, id, action string) error {
+	err := vCenter.Do(ctx, "POST", "/api/vcenter/vm/"+id+"/power?action="+action, nil, nil)
 
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
+	// the VM already has the power state that was asked for
+	if isVCenterError(err, "ALREADY_IN_DESIRED_STATE") {
+		return nil
 	}
 
-	if resp.StatusCode != 204 && resp.StatusCode != 400 {
-		return false
-	}
+	return err
+}
 
-	defer resp.Body.Close()
+// getvCenterPowerState returns POWERED_ON, POWERED_OFF or SUSPENDED
+func getvCenterPowerState(ctx context.Context, id string) (string, error) {
+	var power struct {
+		State string `json:"state"`
+	}
+	err := vCenter.Do(ctx, "GET", "/api/vcenter/vm/"+id+"/power", nil, &power)
 
-	return true
+	return power.State, err
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type vCenterSnapshot struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	// the snapshot the VM is running on top of
+	Current bool `json:"current"`
+}
+
+type vimSnapshotTree struct {
+	Snapshot          vimManagedObjectReference `json:"snapshot"`
+	Name              string                    `json:"name"`
+	Description       string                    `json:"description"`
+	CreateTime        time.Time                 `json:"createTime"`
+	ChildSnapshotList []vimSnapshotTree         `json:"childSnapshotList"`
+}
+
+// listvCenterSnapshots returns all snapshots of the VM, the tree of vCenter is flattened with the oldest first
+func listvCenterSnapshots(ctx context.Context, vmID string) ([]vCenterSnapshot, error) {
+	defer timeTrack(time.Now(), "listvCenterSnapshots")
+
+	var info *struct {
+		CurrentSnapshot  *vimManagedObjectReference `json:"currentSnapshot"`
+		RootSnapshotList []vimSnapshotTree          `json:"rootSnapshotList"`
+	}
+	err := vCenter.DoVim(ctx, "GET", "/VirtualMachine/"+vmID+"/snapshot", nil, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []vCenterSnapshot{}
+	// a VM without snapshots has no snapshot info at all
+	if info == nil {
+		return snapshots, nil
+	}
+
+	var walk func(trees []vimSnapshotTree)
+	walk = func(trees []vimSnapshotTree) {
+		for _, tree := range trees {
+			snapshots = append(snapshots, vCenterSnapshot{
+				ID:          tree.Snapshot.Value,
+				Name:        tree.Name,
+				Description: tree.Description,
+				CreatedAt:   tree.CreateTime,
+				Current:     info.CurrentSnapshot != nil && info.CurrentSnapshot.Value == tree.Snapshot.Value,
+			})
+			walk(tree.ChildSnapshotList)
+		}
+	}
+	walk(info.RootSnapshotList)
+
+	return snapshots, nil
+}
+
+// createvCenterSnapshot makes a snapshot without the memory of the VM, so reverting to it boots the VM again
+func createvCenterSnapshot(ctx context.Context, vmID, name, description string) error {
+	defer timeTrack(time.Now(), "createvCenterSnapshot")
+
+	_, err := runVimTask(ctx, "/VirtualMachine/"+vmID+"/CreateSnapshot_Task", map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"memory":      false,
+		"quiesce":     false,
+	})
+
+	return err
+}
+
+func revertvCenterSnapshot(ctx context.Context, snapshotID string) error {
+	defer timeTrack(time.Now(), "revertvCenterSnapshot")
+
+	_, err := runVimTask(ctx, "/VirtualMachineSnapshot/"+snapshotID+"/RevertToSnapshot_Task", map[string]interface{}{})
+
+	return err
+}
+
+// deletevCenterSnapshot removes only the snapshot itself, its children are kept
+func deletevCenterSnapshot(ctx context.Context, snapshotID string) error {
+	defer timeTrack(time.Now(), "deletevCenterSnapshot")
+
+	_, err := runVimTask(ctx, "/VirtualMachineSnapshot/"+snapshotID+"/RemoveSnapshot_Task", map[string]interface{}{
+		"removeChildren": false,
+	})
+
+	return err
+}
+
+// findvCenterSnapshot makes sure the snapshot belongs to the VM, so users can't touch the snapshots of other servers
+func findvCenterSnapshot(ctx context.Context, vmID, snapshotID string) (vCenterSnapshot, error) {
+	snapshots, err := listvCenterSnapshots(ctx, vmID)
+	if err != nil {
+		return vCenterSnapshot{}, err
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.ID == snapshotID {
+			return snapshot, nil
+		}
+	}
+
+	return vCenterSnapshot{}, fmt.Errorf("snapshot %s not found on VM %s", snapshotID, vmID)
+}
@@ -1,27 +1,27 @@
 package main
 
 import (
-	"encoding/json"
-	"io"
+	"context"
 	"log"
-	"net/http"
 	"strconv"
-	"strings"
 	"time"
 )
 
-func getTemplatesFromVCenter(session string) []string {
+func getTemplatesFromVCenter(ctx context.Context) []string {
 	var templateNames []string
 	if !existsInRedis("templates_last_updated") {
 		setToRedis("templates_last_updated", "0", 0)
 	}
 	templatesLastUpdated := getFromRedis("templates_last_updated")
 
-	templates := fetchTemplateLibraryIdsFromVCenter(session)
+	templates, err := fetchTemplateLibraryIdsFromVCenter(ctx)
+	if err != nil {
+		log.Println("Error fetching templates: ", err)
+	}
 
 	// check if the templates were updated today, otherwise update them
 	if time.Now().Unix()-stringToInt64(templatesLastUpdated) > 86400 {
-		updateTemplatesFromVCenter(session, templates)
+		updateTemplatesFromVCenter(ctx, templates)
 	}
 
 	// get the template names from redis and return them as an array of strings
@@ -31,81 +31,28 @@ func getTemplatesFromVCenter(session string) []string {
 	return templateNames
 }
 
-func fetchTemplateLibraryIdsFromVCenter(session string) []string {
-	client := createVCenterHTTPClient()
-	baseURL := getEnvVar("VCENTER_URL")
-	payload := strings.NewReader("{\"type\":\"vm-template\"}")
-
-	req, err := http.NewRequest("POST", baseURL+"/api/content/library/item?action=find", payload)
-
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("vmware-api-session-id", session)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error sending request: ", err)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Error reading response: ", err)
-	}
-
+func fetchTemplateLibraryIdsFromVCenter(ctx context.Context) ([]string, error) {
 	var templates []string
-	err = json.Unmarshal(body, &templates)
-	if err != nil {
-		log.Println("Error unmarshalling response: ", err)
-	}
-
-	defer resp.Body.Close()
+	err := vCenter.Do(ctx, "POST", "/api/content/library/item?action=find", map[string]string{"type": "vm-template"}, &templates)
 
-	return templates
+	return templates, err
 }
 
-func updateTemplatesFromVCenter(session string, templateIDs []string) {
+func updateTemplatesFromVCenter(ctx context.Context, templateIDs []string) {
 	type vCenterTemplate struct {
 		Name string `json:"name"`
 	}
 	for _, templateID := range templateIDs {
-		client := createVCenterHTTPClient()
-		baseURL := getEnvVar("VCENTER_URL")
-
-		// Create the request
-		req, err := http.NewRequest("GET", baseURL+"/api/content/library/item/"+templateID, nil)
-		if err != nil {
-			log.Println("Error creating request:", err)
-			return
-		}
-
-		// Add the session ID to the request header
-		req.Header.Add("vmware-api-session-id", session)
-		req.Header.Add("Content-Type", "application/json")
-
-		// Make the request
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error making request:", err)
-			return
-		}
-
-		// Read the response body
-		body, err := io.ReadAll(resp.Body)
+		var template vCenterTemplate
+		err := vCenter.Do(ctx, "GET", "/api/content/library/item/"+templateID, nil, &template)
 		if err != nil {
-			log.Println("Error reading response body:", err)
+			log.Println("Error fetching template:", err)
 			return
 		}
 
-		// Unmarshal the response body
-		var template vCenterTemplate
-		err = json.Unmarshal(body, &template)
-
 		// Add the template to redis
 		setToRedis(templateID, template.Name, 0)
 		setToRedis(template.Name, templateID, 0)
-		err = resp.Body.Close()
-		if err != nil {
-			return
-		}
 	}
 
 	// set the time the templates were last updated as unix int to redis
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// the REST API of vCenter doesn't do everything (snapshots, tickets, renaming), for those we use the VI/JSON API,
+// which takes the same session as the REST API
+
+type vimManagedObjectReference struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type vimTaskInfo struct {
+	// queued, running, success or error
+	State  string          `json:"state"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		LocalizedMessage string `json:"localizedMessage"`
+	} `json:"error"`
+}
+
+// runVimTask starts a method that returns a task and waits until vCenter has finished it
+func runVimTask(ctx context.Context, path string, body interface{}) (json.RawMessage, error) {
+	var task vimManagedObjectReference
+	err := vCenter.DoVim(ctx, "POST", path, body, &task)
+	if err != nil {
+		return nil, err
+	}
+
+	return waitForVimTask(ctx, task)
+}
+
+func waitForVimTask(ctx context.Context, task vimManagedObjectReference) (json.RawMessage, error) {
+	ctx, cancel := withVCenterTaskTimeout(ctx)
+	defer cancel()
+
+	for {
+		var info vimTaskInfo
+		err := vCenter.DoVim(ctx, "GET", "/Task/"+task.Value+"/info", nil, &info)
+		if err != nil {
+			return nil, err
+		}
+
+		switch info.State {
+		case "success":
+			return info.Result, nil
+		case "error":
+			if info.Error != nil {
+				return nil, fmt.Errorf("vCenter task %s failed: %s", task.Value, info.Error.LocalizedMessage)
+			}
+			return nil, fmt.Errorf("vCenter task %s failed", task.Value)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("vCenter task %s did not finish: %w", task.Value, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}